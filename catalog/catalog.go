@@ -0,0 +1,221 @@
+// Package catalog keeps the list of all the mirrors published to Swarm.
+// The catalog is uploaded as a collection with a JSON file, an OPDS feed
+// and an HTML front page linking to each mirror.
+package catalog
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+const (
+	// JSONFile is the name of the catalog file read back on each update.
+	JSONFile = "catalog.json"
+	// OPDSFile is the name of the catalog in the OPDS format.
+	OPDSFile = "catalog.xml"
+	// IndexFile is the name of the HTML front page.
+	IndexFile = "index.html"
+)
+
+var templatesDir string
+
+func init() {
+	_, pwd, _, _ := runtime.Caller(0)
+	templatesDir = filepath.Join(filepath.Dir(pwd), "templates")
+}
+
+// Entry describes a mirror of a ZIM file.
+type Entry struct {
+	Zim          string    `json:"zim"`
+	Name         string    `json:"name,omitempty"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description,omitempty"`
+	Language     string    `json:"language,omitempty"`
+	Flavour      string    `json:"flavour,omitempty"`
+	Date         string    `json:"date,omitempty"`
+	ArticleCount uint64    `json:"articleCount"`
+	MediaCount   uint64    `json:"mediaCount"`
+	Reference    string    `json:"reference"`
+	Feed         string    `json:"feed,omitempty"`
+	Published    time.Time `json:"published"`
+}
+
+// URL returns the path of the mirror in a bee node or gateway.
+func (e Entry) URL() string {
+	if e.Feed != "" {
+		return fmt.Sprintf("/bzz/%s/", e.Feed)
+	}
+	return fmt.Sprintf("/bzz/%s/", e.Reference)
+}
+
+// Catalog is the list of published mirrors.
+type Catalog struct {
+	Updated time.Time `json:"updated"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Read reads a catalog in the JSON format.
+func Read(r io.Reader) (*Catalog, error) {
+	var c Catalog
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("error parsing catalog: %v", err)
+	}
+	return &c, nil
+}
+
+// Add adds the entry to the catalog, replacing a previous mirror of the same zim.
+func (c *Catalog) Add(e Entry) {
+	if e.Published.IsZero() {
+		e.Published = time.Now().UTC()
+	}
+	c.Updated = e.Published
+
+	for i := range c.Entries {
+		if c.Entries[i].Zim == e.Zim {
+			c.Entries[i] = e
+			return
+		}
+	}
+	c.Entries = append(c.Entries, e)
+}
+
+// sorted returns the entries ordered by title and date.
+func (c *Catalog) sorted() []Entry {
+	entries := make([]Entry, len(c.Entries))
+	copy(entries, c.Entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Title != entries[j].Title {
+			return entries[i].Title < entries[j].Title
+		}
+		return entries[i].Date > entries[j].Date
+	})
+	return entries
+}
+
+// WriteTar writes the catalog files and its front page to a new tar file.
+func (c *Catalog) WriteTar(tarFile string) error {
+	jsonData, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	opdsData, err := c.opds()
+	if err != nil {
+		return err
+	}
+
+	var index bytes.Buffer
+	tmpl, err := template.ParseFiles(filepath.Join(templatesDir, IndexFile))
+	if err != nil {
+		return fmt.Errorf("error parsing catalog template: %v", err)
+	}
+	if err := tmpl.Execute(&index, map[string]interface{}{
+		"Updated": c.Updated,
+		"Entries": c.sorted(),
+	}); err != nil {
+		return err
+	}
+
+	f, err := os.Create(tarFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{IndexFile, index.Bytes()},
+		{JSONFile, jsonData},
+		{OPDSFile, opdsData},
+	}
+	for _, file := range files {
+		hdr := &tar.Header{
+			Name: file.name,
+			Mode: 0644,
+			Size: int64(len(file.data)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+type opdsFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Entries []opdsEntry `xml:"entry"`
+}
+
+type opdsEntry struct {
+	ID           string   `xml:"id"`
+	Title        string   `xml:"title"`
+	Updated      string   `xml:"updated"`
+	Summary      string   `xml:"summary,omitempty"`
+	Language     string   `xml:"language,omitempty"`
+	Name         string   `xml:"name,omitempty"`
+	Flavour      string   `xml:"flavour,omitempty"`
+	ArticleCount uint64   `xml:"articleCount"`
+	MediaCount   uint64   `xml:"mediaCount"`
+	Link         opdsLink `xml:"link"`
+}
+
+type opdsLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// opds returns the catalog as an OPDS feed, similar to the Kiwix catalog.
+func (c *Catalog) opds() ([]byte, error) {
+	feed := opdsFeed{
+		ID:      "urn:beezim:catalog",
+		Title:   "Beezim Swarm mirrors",
+		Updated: c.Updated.Format(time.RFC3339),
+	}
+	for _, e := range c.sorted() {
+		feed.Entries = append(feed.Entries, opdsEntry{
+			ID:           "urn:swarm:" + e.Reference,
+			Title:        e.Title,
+			Updated:      e.Published.Format(time.RFC3339),
+			Summary:      e.Description,
+			Language:     e.Language,
+			Name:         e.Name,
+			Flavour:      e.Flavour,
+			ArticleCount: e.ArticleCount,
+			MediaCount:   e.MediaCount,
+			Link: opdsLink{
+				Rel:  "alternate",
+				Type: "text/html",
+				Href: e.URL(),
+			},
+		})
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
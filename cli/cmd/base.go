@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient/manifest"
+	"github.com/r0qs/beezim/internal/tarball"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// baseMirror is a previous mirror whose entries are reused by an incremental
+// mirror when their content did not change.
+type baseMirror struct {
+	addr swarm.Address
+	// reused maps the paths of the tar file unchanged since the base mirror
+	// to their reference in it
+	reused map[string]string
+	// uploadSize is the size of the entries of the tar file to upload
+	uploadSize int64
+	entries    int
+}
+
+// resolveBaseMirror returns the reference of the mirror given by --base, which
+// is either a reference or the zim or tar name of a mirror in the registry.
+// The base must be encrypted like the new mirror, since reusing its entries
+// would otherwise expose them.
+func resolveBaseMirror(key string, encrypt bool) (swarm.Address, error) {
+	reg, err := openRegistry()
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	records := reg.Find(key)
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Encrypted == encrypt {
+			return parseCollectionReference(records[i].Reference)
+		}
+	}
+	if len(records) > 0 {
+		return swarm.Address{}, fmt.Errorf("base mirror %s was not uploaded with --%s=%t", key, optionNameEncrypt, encrypt)
+	}
+
+	addr, err := parseCollectionReference(key)
+	if err != nil {
+		return swarm.Address{}, fmt.Errorf("base mirror %s is not in the registry %s nor a reference", key, reg.Path())
+	}
+	if encrypted := len(addr.Bytes()) == swarm.HashSize*2; encrypted != encrypt {
+		return swarm.Address{}, fmt.Errorf("base mirror %s was not uploaded with --%s=%t", key, optionNameEncrypt, encrypt)
+	}
+	return addr, nil
+}
+
+// diffBaseMirror compares the content hashes of the entries of the tar file,
+// recorded in its files.json, with the ones recorded in the files.json of the
+// base mirror, and returns the references of the unchanged entries in the
+// base mirror.
+func diffBaseMirror(ctx context.Context, tarPath string, addr swarm.Address) (*baseMirror, error) {
+	m := manifest.NewReader(bee, addr)
+	files, err := fetchFilesIndex(ctx, m, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string, len(files))
+	for p, f := range files {
+		if f.Metadata.Hash != "" {
+			hashes[p] = f.Metadata.Hash
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("the %s of the base mirror %s has no content hashes: only mirrors parsed by this version can be a base", filesIndex, addr)
+	}
+
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := listTarEntries(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no files in tar")
+	}
+	tarHashes, err := readTarHashes(f, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	// the entries with the hash of the base mirror are looked up in a single
+	// walk of its manifest
+	unchanged := make(map[string]bool)
+	for _, e := range entries {
+		if hash := hashes[e.path]; hash != "" {
+			same, err := sameContent(hash, tarHashes[e.path], e.path, io.NewSectionReader(f, e.offset, e.size))
+			if err != nil {
+				return nil, err
+			}
+			unchanged[e.path] = same
+		}
+	}
+
+	base := &baseMirror{
+		addr:    addr,
+		reused:  make(map[string]string),
+		entries: len(entries),
+	}
+	header := fmt.Sprintf("Comparing with base mirror: %s", filepath.Base(tarPath))
+	progressBar := newNetProgressBar(header, len(unchanged), true)
+	progressBar.Start()
+	err = m.Walk(ctx, func(p string, e manifest.Entry) error {
+		if same, ok := unchanged[p]; ok {
+			progressBar.Increment()
+			if same {
+				base.reused[p] = e.Reference.String()
+			}
+		}
+		return nil
+	})
+	progressBar.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("walk base mirror: %v", err)
+	}
+
+	for _, e := range entries {
+		if _, ok := base.reused[e.path]; !ok {
+			base.uploadSize += e.size
+		}
+	}
+	return base, nil
+}
+
+// readTarHashes reads the content hashes recorded in the files.json of the
+// tar file. It returns no hashes if the tar file has no files.json, which is
+// only written with --enable-search.
+func readTarHashes(f *os.File, entries []tarEntry) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, e := range entries {
+		if e.path != filesIndex {
+			continue
+		}
+		var files map[string]indexer.IndexEntry
+		if err := json.NewDecoder(io.NewSectionReader(f, e.offset, e.size)).Decode(&files); err != nil {
+			return nil, fmt.Errorf("invalid %s in tar: %v", filesIndex, err)
+		}
+		for p, f := range files {
+			if f.Metadata.Hash != "" {
+				hashes[p] = f.Metadata.Hash
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// sameContent reports if the entry of the tar file has the hash recorded for
+// it in the base mirror. The entry is only hashed if the tar file has no hash
+// recorded for it.
+func sameContent(baseHash string, tarHash string, p string, r io.Reader) (bool, error) {
+	if tarHash != "" {
+		return tarHash == baseHash, nil
+	}
+	h := tarball.FileHasher()
+	if _, err := io.Copy(h, r); err != nil {
+		return false, fmt.Errorf("read %s from tar: %v", p, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)) == baseHash, nil
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+func contentHash(t *testing.T, content string) string {
+	t.Helper()
+	h := tarball.FileHasher()
+	if _, err := io.WriteString(h, content); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestBaseMirrorHashes(t *testing.T) {
+	content := map[string]string{
+		"A/Same":     "same",
+		"A/Changed":  "new",
+		"A/Unhashed": "unhashed",
+	}
+	files := map[string]indexer.IndexEntry{
+		"A/Same":     {Path: "A/Same", Metadata: indexer.IndexMetadata{Hash: contentHash(t, "same")}},
+		"A/Changed":  {Path: "A/Changed", Metadata: indexer.IndexMetadata{Hash: contentHash(t, "new")}},
+		"A/Unhashed": {Path: "A/Unhashed"},
+	}
+	index, err := json.Marshal(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[filesIndex] = string(index)
+
+	tarPath := filepath.Join(t.TempDir(), "wikipedia.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for p, c := range content {
+		if err := tw.WriteHeader(&tar.Header{Name: p, Mode: 0644, Size: int64(len(c))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := listTarEntries(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarHashes, err := readTarHashes(f, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tarHashes) != 2 || tarHashes["A/Same"] != files["A/Same"].Metadata.Hash {
+		t.Errorf("got hashes %v, want the two hashes of the files.json", tarHashes)
+	}
+
+	// the base mirror has the old content of A/Changed, and the entry
+	// without hash in the tar is hashed
+	baseHashes := map[string]string{
+		"A/Same":     contentHash(t, "same"),
+		"A/Changed":  contentHash(t, "old"),
+		"A/Unhashed": contentHash(t, "unhashed"),
+	}
+	want := map[string]bool{"A/Same": true, "A/Changed": false, "A/Unhashed": true}
+	for _, e := range entries {
+		if e.path == filesIndex {
+			continue
+		}
+		same, err := sameContent(baseHashes[e.path], tarHashes[e.path], e.path, io.NewSectionReader(f, e.offset, e.size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if same != want[e.path] {
+			t.Errorf("got unchanged %t for %s, want %t", same, e.path, want[e.path])
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/r0qs/beezim/catalog"
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/httpclient"
+	"github.com/r0qs/beezim/internal/kiwix"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+// defaultCatalogFeed is the name of the feed of the catalog of mirrors.
+const defaultCatalogFeed = "beezim-catalog"
+
+func newCatalogCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "catalog",
+		Short: "Show the catalog of mirrors published to swarm",
+		Long:  "\nThe mirrors published by mirror are added to a catalog uploaded to swarm behind a feed, so a single url lists all of them.",
+	}
+	cmd.PersistentFlags().StringVar(&optionListFeed, optionNameCatalogFeed, defaultCatalogFeed, "name of the feed of the catalog")
+	cmd.PersistentFlags().StringVar(&optionFeedKey, optionNameFeedKey, "", "path to the hex private key signing the feed updates (default \"~/.beezim/feed.key\")")
+	cmd.AddCommand(
+		newCatalogListCmd(),
+	)
+
+	return cmd
+}
+
+// Catalog Subcommands
+func newCatalogListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the mirrors in the catalog",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, owner, topic, err := feedOf(optionListFeed)
+			if err != nil {
+				return err
+			}
+
+			c, err := fetchCatalog(cmd.Context(), owner, topic)
+			if err != nil {
+				return err
+			}
+			return printCatalog(c)
+		},
+	}
+	cmd.Flags().BoolVar(&optionJSON, optionNameJSON, false, "print the catalog as JSON")
+
+	return cmd
+}
+
+// fetchCatalog downloads the catalog from the latest update of its feed.
+// An empty catalog is returned if the feed was never updated.
+func fetchCatalog(ctx context.Context, owner []byte, topic []byte) (*catalog.Catalog, error) {
+	update, err := bee.FeedUpdate(ctx, owner, topic)
+	if errors.Is(err, httpclient.ErrNotFound) {
+		return &catalog.Catalog{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup catalog feed: %v", err)
+	}
+
+	data, err := bee.DownloadManifestBytes(ctx, update.Reference, catalog.JSONFile)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Read(bytes.NewReader(data))
+}
+
+// catalogEntry describes the mirror of the zim, using the zim metadata
+// or, if missing, the information in the zim file name.
+func catalogEntry(zimPath string) (catalog.Entry, error) {
+	sidx, err := indexer.New(zimPath, indexer.Options{})
+	if err != nil {
+		return catalog.Entry{}, err
+	}
+	defer sidx.Close()
+
+	m := sidx.Metadata()
+	zimFile := filepath.Base(zimPath)
+	file := kiwix.File{Name: zimFile}
+
+	e := catalog.Entry{
+		Zim:          zimFile,
+		Name:         m.Name,
+		Title:        m.Title,
+		Description:  m.Description,
+		Language:     m.Language,
+		Flavour:      m.Flavour,
+		Date:         m.Date,
+		ArticleCount: m.ArticleCount,
+		MediaCount:   m.MediaCount,
+	}
+	if e.Name == "" {
+		e.Name = file.Release()
+	}
+	if e.Title == "" {
+		e.Title = e.Name
+	}
+	if e.Language == "" {
+		e.Language = file.Language()
+	}
+	if e.Flavour == "" {
+		e.Flavour = file.Flavour()
+	}
+	if e.Date == "" {
+		e.Date = file.Date()
+	}
+	return e, nil
+}
+
+// updateCatalog adds the entry to the catalog, uploads it and points the
+// catalog feed to it, returning the feed manifest of the catalog.
+func updateCatalog(ctx context.Context, feedName string, e catalog.Entry, batchID string) (swarm.Address, error) {
+	_, owner, topic, err := feedOf(feedName)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	c, err := fetchCatalog(ctx, owner, topic)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	c.Add(e)
+
+	tarFile := feedName + ".tar"
+	tarPath := filepath.Join(optionDataDir, tarFile)
+	if err := c.WriteTar(tarPath); err != nil {
+		return swarm.Address{}, err
+	}
+	defer os.Remove(tarPath)
+
+	addr, err := uploadTarFile(ctx, tarPath, tarFile, &api.UploadCollectionOptions{
+		MimeType:            api.ContentTypeTar,
+		Pin:                 optionBeePin,
+		BatchID:             batchID,
+		IndexDocumentHeader: catalog.IndexFile,
+		ErrorDocumentHeader: catalog.IndexFile,
+	})
+	if err != nil {
+		return swarm.Address{}, fmt.Errorf("upload catalog: %v", err)
+	}
+	log.Printf("catalog with %d mirrors uploaded with reference: %v", len(c.Entries), addr)
+
+	return publishFeed(ctx, feedName, addr, batchID)
+}
+
+func printCatalog(c *catalog.Catalog) error {
+	if optionJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(c)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 2, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Title\tZim\tLanguage\tDate\tArticles\tReference\t\n")
+	for _, e := range c.Entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t\n", e.Title, e.Zim, e.Language, e.Date, e.ArticleCount, e.Reference)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkpointSuffix is appended to the name of a tar file
+// to name the checkpoint of its resumable upload.
+const checkpointSuffix = ".checkpoint"
+
+// checkpointHeader identifies the tar file a checkpoint belongs to.
+type checkpointHeader struct {
+	Tar       string    `json:"tar"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+	Encrypted bool      `json:"encrypted,omitempty"`
+}
+
+// checkpointRecord is an entry of the tar file uploaded with its reference,
+// or the reference of the manifest once the whole tar file is uploaded.
+type checkpointRecord struct {
+	Path      string `json:"path,omitempty"`
+	Reference string `json:"reference"`
+	Manifest  bool   `json:"manifest,omitempty"`
+}
+
+// uploadCheckpoint records the progress of a resumable upload as a JSON line
+// per uploaded entry, so an interrupted upload only sends the remaining ones.
+// A line cut by an interruption is ignored when the checkpoint is loaded.
+type uploadCheckpoint struct {
+	mu       sync.Mutex
+	f        *os.File
+	entries  map[string]string
+	manifest string
+}
+
+// openCheckpoint loads the checkpoint of the tar file, or creates it if it
+// does not exist. It fails if the tar file changed since it was created,
+// or if it was uploaded with another encryption setting.
+func openCheckpoint(path string, tar os.FileInfo, encrypted bool) (*uploadCheckpoint, error) {
+	header := newCheckpointHeader(tar, encrypted)
+	cp := &uploadCheckpoint{entries: make(map[string]string)}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if cp.f, err = os.Create(path); err != nil {
+			return nil, err
+		}
+		if err := cp.write(header); err != nil {
+			cp.Close()
+			return nil, err
+		}
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	h, ok := scanCheckpointHeader(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid checkpoint %s: remove it to upload again", path)
+	}
+	if !h.sameTar(header) {
+		return nil, fmt.Errorf("tar file %s changed since the checkpoint %s: remove it to upload again", tar.Name(), path)
+	}
+	if h.Encrypted != header.Encrypted {
+		return nil, fmt.Errorf("checkpoint %s is of an upload with encryption %t: remove it to upload again", path, h.Encrypted)
+	}
+	for s.Scan() {
+		var rec checkpointRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			continue
+		}
+		if rec.Manifest {
+			cp.manifest = rec.Reference
+		} else {
+			cp.entries[rec.Path] = rec.Reference
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if cp.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	// start a new line in case the last one was cut
+	if _, err := cp.f.Write([]byte("\n")); err != nil {
+		cp.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// hasCheckpoint reports whether the tar file has a checkpoint of an upload
+// with the same encryption setting made since it was last written, so a new
+// run must keep the tar file to resume the upload.
+func hasCheckpoint(tarPath string, encrypted bool) bool {
+	tar, err := os.Stat(tarPath)
+	if err != nil {
+		return false
+	}
+	f, err := os.Open(tarPath + checkpointSuffix)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h, ok := scanCheckpointHeader(bufio.NewScanner(f))
+	header := newCheckpointHeader(tar, encrypted)
+	return ok && h.sameTar(header) && h.Encrypted == header.Encrypted
+}
+
+func newCheckpointHeader(tar os.FileInfo, encrypted bool) checkpointHeader {
+	return checkpointHeader{
+		Tar:       tar.Name(),
+		Size:      tar.Size(),
+		ModTime:   tar.ModTime().UTC(),
+		Encrypted: encrypted,
+	}
+}
+
+func scanCheckpointHeader(s *bufio.Scanner) (checkpointHeader, bool) {
+	var h checkpointHeader
+	if !s.Scan() || json.Unmarshal(s.Bytes(), &h) != nil {
+		return checkpointHeader{}, false
+	}
+	return h, true
+}
+
+// sameTar reports whether both headers identify the same tar file.
+func (h checkpointHeader) sameTar(o checkpointHeader) bool {
+	return h.Tar == o.Tar && h.Size == o.Size && h.ModTime.Equal(o.ModTime)
+}
+
+// Reference returns the reference of the uploaded entry, if any.
+func (cp *uploadCheckpoint) Reference(path string) (string, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	ref, ok := cp.entries[path]
+	return ref, ok
+}
+
+// Entries returns the uploaded entries and their references.
+func (cp *uploadCheckpoint) Entries() map[string]string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	entries := make(map[string]string, len(cp.entries))
+	for path, ref := range cp.entries {
+		entries[path] = ref
+	}
+	return entries
+}
+
+// Add records an uploaded entry.
+func (cp *uploadCheckpoint) Add(path, ref string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.entries[path] = ref
+	return cp.write(checkpointRecord{Path: path, Reference: ref})
+}
+
+// Manifest returns the reference of the manifest, if the upload completed.
+func (cp *uploadCheckpoint) Manifest() string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.manifest
+}
+
+// SetManifest records the reference of the manifest, completing the upload.
+func (cp *uploadCheckpoint) SetManifest(ref string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.manifest = ref
+	return cp.write(checkpointRecord{Reference: ref, Manifest: true})
+}
+
+func (cp *uploadCheckpoint) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = cp.f.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the checkpoint file.
+func (cp *uploadCheckpoint) Close() error {
+	return cp.f.Close()
+}
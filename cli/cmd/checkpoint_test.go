@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointResumesUpload(t *testing.T) {
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "wikipedia.tar")
+	if err := os.WriteFile(tarPath, []byte("tar content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tar, err := os.Stat(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpPath := tarPath + checkpointSuffix
+
+	if hasCheckpoint(tarPath, false) {
+		t.Fatal("tar file has a checkpoint before its upload")
+	}
+
+	cp, err := openCheckpoint(cpPath, tar, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Add("A/Main_Page", "aa"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !hasCheckpoint(tarPath, false) {
+		t.Error("tar file has no checkpoint after an interrupted upload")
+	}
+	if hasCheckpoint(tarPath, true) {
+		t.Error("checkpoint of an unencrypted upload resumes an encrypted one")
+	}
+
+	// a line cut by an interruption is ignored
+	f, err := os.OpenFile(cpPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"path":"A/Sw`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	cp, err = openCheckpoint(cpPath, tar, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref, ok := cp.Reference("A/Main_Page"); !ok || ref != "aa" {
+		t.Errorf("got reference %q, %t of the uploaded entry, want %q", ref, ok, "aa")
+	}
+	if err := cp.Add("A/Swarm", "bb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.SetManifest("cc"); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	cp, err = openCheckpoint(cpPath, tar, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if got := cp.Entries(); len(got) != 2 || got["A/Swarm"] != "bb" {
+		t.Errorf("got entries %v, want the two uploaded entries", got)
+	}
+	if cp.Manifest() != "cc" {
+		t.Errorf("got manifest %q, want %q", cp.Manifest(), "cc")
+	}
+}
+
+func TestCheckpointOfRewrittenTar(t *testing.T) {
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "wikipedia.tar")
+	if err := os.WriteFile(tarPath, []byte("tar content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tar, err := os.Stat(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := openCheckpoint(tarPath+checkpointSuffix, tar, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	// the tar file is written again by a new parse
+	later := tar.ModTime().Add(time.Minute)
+	if err := os.Chtimes(tarPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if hasCheckpoint(tarPath, false) {
+		t.Error("checkpoint resumes the upload of a rewritten tar file")
+	}
+	tar, err = os.Stat(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openCheckpoint(tarPath+checkpointSuffix, tar, false); err == nil {
+		t.Error("checkpoint opened for a rewritten tar file")
+	}
+}
@@ -10,10 +10,13 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"time"
 
 	pb "github.com/cheggaaa/pb/v3"
 	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/httpclient"
 
+	"github.com/ethersphere/bee/pkg/swarm"
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
@@ -38,6 +41,28 @@ var (
 	optionBeeBatchID     string
 	optionBeeBatchDepth  uint64
 	optionBeeBatchAmount int64
+	optionBeeBatchTTL    time.Duration
+	optionBatchLabel     string
+	optionWaitUsable     bool
+	optionJSON           bool
+	optionRegistryPath   string
+	optionForce          bool
+	optionFeed           string
+	optionFeedKey        string
+	optionCatalogFeed    string
+	optionNoCatalog      bool
+	optionListFeed       string
+	optionWaitSynced     bool
+	optionRetries        int
+	optionResume         bool
+	optionEncrypt        bool
+	optionReference      string
+	optionSample         int
+	optionOutput         string
+	optionIncludeZim     bool
+	optionBase           string
+	optionUploadWorkers  int
+	optionRequestTimeout time.Duration
 	optionBeeTag         uint32
 	optionBeePin         bool
 	optionGatewayMode    bool
@@ -48,6 +73,18 @@ var (
 	optionTarFile        string
 	optionExtractOnly    bool
 	optionEnableSearch   bool
+	optionParseWorkers   int
+	optionPrefetchMB     int
+	optionIncludeNS      []string
+	optionExcludeNS      []string
+	optionRemapNS        []string
+	optionRedirectMode   string
+	optionCatalogURL     string
+	optionLang           string
+	optionFlavour        string
+	optionZimName        string
+	optionLatest         bool
+	optionParallel       int
 	optionCPUProfile     string
 	optionMEMProfile     string
 	optionBlockProfile   string
@@ -61,6 +98,27 @@ const (
 	optionNameBeeBatchID     = "batch-id"
 	optionNameBeeBatchDepth  = "batch-depth"
 	optionNameBeeBatchAmount = "batch-amount"
+	optionNameBeeBatchTTL    = "batch-ttl"
+	optionNameBatchLabel     = "label"
+	optionNameWaitUsable     = "wait-usable"
+	optionNameJSON           = "json"
+	optionNameRegistryPath   = "registry"
+	optionNameForce          = "force"
+	optionNameFeed           = "feed"
+	optionNameFeedKey        = "feed-key"
+	optionNameCatalogFeed    = "catalog-feed"
+	optionNameNoCatalog      = "no-catalog"
+	optionNameWaitSynced     = "wait-synced"
+	optionNameRetries        = "retries"
+	optionNameResume         = "resume"
+	optionNameEncrypt        = "encrypt"
+	optionNameReference      = "ref"
+	optionNameSample         = "sample"
+	optionNameOutput         = "out"
+	optionNameIncludeZim     = "include-zim"
+	optionNameBase           = "base"
+	optionNameUploadWorkers  = "upload-workers"
+	optionNameRequestTimeout = "request-timeout"
 	optionNameBeeTag         = "tag"
 	optionNameBeePin         = "pin"
 	optionNameGatewayMode    = "gateway"
@@ -71,6 +129,18 @@ const (
 	optionNameTarFile        = "tar"
 	optionNameExtractOnly    = "extract-only"
 	optionNameEnableSearch   = "enable-search"
+	optionNameParseWorkers   = "workers"
+	optionNamePrefetchMB     = "prefetch-mb"
+	optionNameIncludeNS      = "include-namespaces"
+	optionNameExcludeNS      = "exclude-namespaces"
+	optionNameRemapNS        = "remap-namespaces"
+	optionNameRedirectMode   = "redirects"
+	optionNameCatalogURL     = "catalog"
+	optionNameLang           = "lang"
+	optionNameFlavour        = "flavour"
+	optionNameZimName        = "name"
+	optionNameLatest         = "latest"
+	optionNameParallel       = "parallel"
 	optionNameCPUProfile     = "cpuprofile"
 	optionNameMEMProfile     = "memprofile"
 	optionNameBlockProfile   = "blockprofile"
@@ -91,14 +161,19 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&optionGasPrice, optionNameGasPrice, "", "gas price for postage stamps purchase")
 	rootCmd.PersistentFlags().StringVar(&optionBeeApiUrl, optionNameBeeApiUrl, os.Getenv("BEE_API_URL"), "bee api url")
 	rootCmd.PersistentFlags().StringVar(&optionBeeDebugApiUrl, optionNameBeeDebugApiUrl, os.Getenv("BEE_DEBUG_API_URL"), "bee debug api url")
-	rootCmd.PersistentFlags().StringVar(&optionBeeBatchID, optionNameBeeBatchID, "", "bee postage batch ID")
-	rootCmd.PersistentFlags().Uint64Var(&optionBeeBatchDepth, optionNameBeeBatchDepth, 30, "bee postage batch depth")
-	rootCmd.PersistentFlags().Int64Var(&optionBeeBatchAmount, optionNameBeeBatchAmount, 100000000, "bee postage batch amount")
-	rootCmd.PersistentFlags().Uint32Var(&optionBeeTag, optionNameBeeTag, 0, "bee tag UID to the attached to the uploaded data")
+	rootCmd.PersistentFlags().StringVar(&optionBeeBatchID, optionNameBeeBatchID, "", "bee postage batch ID (a new batch is bought if not set)")
+	rootCmd.PersistentFlags().Uint64Var(&optionBeeBatchDepth, optionNameBeeBatchDepth, 0, "depth of a bought postage batch (default estimated from the upload size)")
+	rootCmd.PersistentFlags().Int64Var(&optionBeeBatchAmount, optionNameBeeBatchAmount, 0, "amount of a bought postage batch (default estimated from --batch-ttl)")
+	rootCmd.PersistentFlags().DurationVar(&optionBeeBatchTTL, optionNameBeeBatchTTL, 30*24*time.Hour, "time to live of a bought postage batch")
+	rootCmd.PersistentFlags().Uint32Var(&optionBeeTag, optionNameBeeTag, 0, "bee tag UID to the attached to the uploaded data (default a new tag per upload)")
 	rootCmd.PersistentFlags().BoolVar(&optionBeePin, optionNameBeePin, false, "whether the uploaded data should be locally pinned on a node")
+	rootCmd.PersistentFlags().BoolVar(&optionWaitSynced, optionNameWaitSynced, false, "wait until the uploaded chunks are synced to the network")
+	rootCmd.PersistentFlags().IntVar(&optionRetries, optionNameRetries, httpclient.DefaultMaxAttempts, "maximum number of attempts of a failed request to bee or of a checksum download")
+	rootCmd.PersistentFlags().DurationVar(&optionRequestTimeout, optionNameRequestTimeout, time.Minute, "timeout of each request to bee and checksum download, except data uploads and downloads (0 disables it)")
 	rootCmd.PersistentFlags().BoolVar(&optionGatewayMode, optionNameGatewayMode, false, fmt.Sprintf("connect to the swarm public gateway (default \"%s\")", os.Getenv("BEE_GATEWAY")))
 	rootCmd.PersistentFlags().StringVar(&optionDataDir, optionNameDataDir, "", "path to datadir directory (default \"./datadir\")")
 	rootCmd.PersistentFlags().BoolVar(&optionClean, optionNameClean, false, "delete all downloaded zim and generated tar files")
+	rootCmd.PersistentFlags().StringVar(&optionRegistryPath, optionNameRegistryPath, "", "path to the registry of published mirrors (default \"~/.beezim/registry.json\")")
 	rootCmd.PersistentFlags().BoolVar(&optionEnableSearch, optionNameEnableSearch, false, "enable search index")
 	rootCmd.PersistentFlags().StringVar(&optionCPUProfile, optionNameCPUProfile, "", "write cpu profile to file")
 	rootCmd.PersistentFlags().StringVar(&optionMEMProfile, optionNameMEMProfile, "", "write memory profile to file")
@@ -138,12 +213,17 @@ var rootCmd = &cobra.Command{
 
 func Execute() (err error) {
 	rootCmd.AddCommand(
-		listWebCmd,
+		newListCmd(),
 		newDownloadCmd(),
 		newUploadCmd(),
 		newParserCmd(),
 		newMirrorCmd(),
 		newCleanCmd(),
+		newStampsCmd(),
+		newRegistryCmd(),
+		newCatalogCmd(),
+		newVerifyCmd(),
+		newExportCmd(),
 	)
 
 	return rootCmd.Execute()
@@ -171,9 +251,30 @@ func makeURL(filePath string) string {
 	return path.Join(optionBeeApiUrl, "bzz", filePath)
 }
 
+// parseCollectionReference parses the reference of a collection,
+// which is 64 bytes long if the collection is encrypted.
+func parseCollectionReference(ref string) (swarm.Address, error) {
+	addr, err := swarm.ParseHexAddress(ref)
+	if err != nil || (len(addr.Bytes()) != swarm.HashSize && len(addr.Bytes()) != swarm.HashSize*2) {
+		return swarm.Address{}, fmt.Errorf("invalid collection reference %q", ref)
+	}
+	return addr, nil
+}
+
+// requestRetryOptions returns the retry policy set by --retries.
+func requestRetryOptions() httpclient.RetryOptions {
+	retry := httpclient.DefaultRetryOptions()
+	retry.MaxAttempts = optionRetries
+	return retry
+}
+
 func NewBeeClient(beeApiUrl string, beeDebugApiUrl string) (*beeclient.BeeClient, error) {
 	var err error
-	opts := beeclient.ClientOptions{}
+	retry := requestRetryOptions()
+	opts := beeclient.ClientOptions{
+		Retry:          &retry,
+		RequestTimeout: optionRequestTimeout,
+	}
 
 	opts.APIURL, err = url.Parse(beeApiUrl)
 	if err != nil {
@@ -254,5 +355,4 @@ func stopProfiling() {
 	}
 }
 
-//TODO: Buy stamps
 //TODO: Make manifest metadata
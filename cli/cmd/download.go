@@ -1,14 +1,26 @@
 package cmd
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/r0qs/beezim/internal/httpclient"
+	"github.com/r0qs/beezim/internal/kiwix"
 
 	"github.com/spf13/cobra"
 )
@@ -19,18 +31,20 @@ func newDownloadCmd() *cobra.Command {
 		Use:   "download",
 		Short: "Download zim file",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			_, err := download(optionDataDir, optionZimFile, optionZimURL)
+			_, err := download(cmd.Context(), optionDataDir, optionZimFile, optionZimURL)
 			return err
 		},
 	}
 	cmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "path to the zim file")
 	cmd.Flags().StringVar(&optionZimURL, optionNameZimURL, "", "download URL for the zim files")
-	// TODO: add download all option
+	cmd.AddCommand(
+		newDownloadAllCmd(),
+	)
 
 	return cmd
 }
 
-func download(dataDir, zimFile, zimURL string) (string, error) {
+func download(ctx context.Context, dataDir, zimFile, zimURL string) (string, error) {
 	if zimFile != "" && zimURL == "" {
 		if filepath.Ext(zimFile) != ".zim" {
 			return "", fmt.Errorf("file must has .zim extention")
@@ -44,48 +58,374 @@ func download(dataDir, zimFile, zimURL string) (string, error) {
 		return "", fmt.Errorf("--zim or --url should be provided")
 	}
 
-	zimDownloadPath := fmt.Sprintf("%s/%s", dataDir, zimFile)
+	// files are only moved to the datadir after being verified,
+	// so an existing file is a complete download.
+	zimDownloadPath := filepath.Join(dataDir, zimFile)
 	if _, err := os.Stat(zimDownloadPath); os.IsNotExist(err) {
-		if err := downloadZim(zimURL, zimDownloadPath); err != nil {
+		if err := downloadZim(ctx, zimURL, zimDownloadPath, nil); err != nil {
 			return "", err
 		}
 	}
 	return zimDownloadPath, nil
 }
 
-// TODO: continue the download from where it stopped in case of crash
+// downloadZim downloads the zim file to a ".part" file next to dstFile,
+// resuming from where a previous download stopped. Once completed, the file
+// is verified against the checksum published by the mirror and renamed to dstFile.
+// If a pool is given, the download progress is rendered by it.
 // TODO: keep track of already uploaded files (in the metadata kv)
-func downloadZim(targetURL string, dstFile string) error {
-	resp, err := http.Get(targetURL)
+func downloadZim(ctx context.Context, targetURL string, dstFile string, pool *progressPool) error {
+	partFile := dstFile + ".part"
+
+	dest, err := os.OpenFile(partFile, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed: %v [status: %v]\n", targetURL, resp.Status)
-	}
+	defer dest.Close()
 
-	size, err := strconv.Atoi(resp.Header.Get("Content-Length"))
+	offset, err := dest.Seek(0, io.SeekEnd)
 	if err != nil {
 		return err
 	}
 
-	dest, err := os.Create(dstFile)
+	resp, start, err := requestZim(ctx, targetURL, offset)
 	if err != nil {
 		return err
 	}
-	defer dest.Close()
 
-	header := fmt.Sprintf("Downloading zim file: %s", filepath.Base(dstFile))
-	progressBar := newNetProgressBar(header, size, true)
-	progressBar.Start()
+	if resp != nil {
+		defer resp.Body.Close()
 
-	io.Copy(dest, progressBar.NewProxyReader(resp.Body))
+		if start < offset {
+			if err := dest.Truncate(start); err != nil {
+				return err
+			}
+			if _, err := dest.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		} else if start > 0 {
+			log.Printf("Resuming download of %s from byte %d", filepath.Base(dstFile), start)
+		}
 
-	progressBar.Finish()
+		size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return err
+		}
+
+		header := fmt.Sprintf("Downloading zim file: %s", filepath.Base(dstFile))
+		progressBar := newNetProgressBar(header, int(start+size), true)
+		progressBar.SetCurrent(start)
+
+		var r io.Reader = progressBar.NewProxyReader(resp.Body)
+		if pool != nil {
+			r = pool.Track(progressBar, r, size)
+		}
+		progressBar.Start()
+
+		_, err = io.Copy(dest, r)
+		progressBar.Finish()
+		if err != nil {
+			return fmt.Errorf("download interrupted, run it again to resume: %v", err)
+		}
+	}
+
+	if err := dest.Close(); err != nil {
+		return err
+	}
+
+	if err := verifyDownload(ctx, targetURL, partFile); err != nil {
+		return err
+	}
+
+	if err := os.Rename(partFile, dstFile); err != nil {
+		return err
+	}
 
 	// TODO: use a proper logger and make log messages optional by level (info, debug, etc)
 	log.Printf("Zim file saved to: %s \n", dstFile)
 	return nil
 }
+
+// requestZim requests the zim file from the given offset, where the part file
+// downloaded so far ends. It returns the response and the offset its body
+// starts from, which is zero when the download starts over, or no response if
+// the part file is already complete.
+func requestZim(ctx context.Context, targetURL string, offset int64) (*http.Response, int64, error) {
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			// the server does not support ranges, so start over
+			return resp, 0, nil
+		case http.StatusPartialContent:
+			start, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+			if err == nil && start == offset {
+				return resp, offset, nil
+			}
+			resp.Body.Close()
+			if offset == 0 {
+				return nil, 0, fmt.Errorf("download failed: %v [status: %v without range]", targetURL, resp.Status)
+			}
+			log.Printf("The server sent %s from another byte than %d: starting the download over", targetURL, offset)
+		case http.StatusRequestedRangeNotSatisfiable:
+			resp.Body.Close()
+			// the part file is already complete if it has the size of the zim
+			_, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+			if err == nil && size == offset {
+				return nil, offset, nil
+			}
+			if offset == 0 {
+				return nil, 0, fmt.Errorf("download failed: %v [status: %v without range]", targetURL, resp.Status)
+			}
+			log.Printf("The part file of %d bytes does not match the size of %s: starting the download over", offset, targetURL)
+		default:
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("download failed: %v [status: %v]\n", targetURL, resp.Status)
+		}
+		offset = 0
+	}
+}
+
+// parseContentRange parses a Content-Range header of bytes, as in
+// "bytes 100-199/200" or "bytes */200" (see RFC 7233). The first byte or
+// the size are -1 when they are not given.
+func parseContentRange(v string) (start int64, size int64, err error) {
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(v, "bytes "), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+
+	start, size = -1, -1
+	if parts[0] != "*" {
+		bounds := strings.SplitN(parts[0], "-", 2)
+		if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid content range %q", v)
+		}
+	}
+	if parts[1] != "*" {
+		if size, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid content range %q", v)
+		}
+	}
+	return start, size, nil
+}
+
+// Download Subcommands
+func newDownloadAllCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "all",
+		Short: "Download all zim files of a specifc kiwix mirror",
+		Long:  "\nDownloads concurrently all zim files of the kiwix mirror given by --kiwix that match the filters.\nFiles already downloaded to the datadir are skipped.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter := kiwix.Filter{
+				Language: optionLang,
+				Flavour:  optionFlavour,
+				Name:     optionZimName,
+			}
+
+			dirURL := fmt.Sprintf("%s/%s/", kiwixZimURL, optionKiwix)
+			zimPaths, err := downloadAllFrom(cmd.Context(), optionDataDir, dirURL, filter, optionLatest, optionParallel)
+			if err != nil {
+				return err
+			}
+			for _, zimPath := range zimPaths {
+				log.Printf("Zim file available at: %s", zimPath)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&optionLang, optionNameLang, "", "download only zim files of the given language (e.g. en)")
+	cmd.Flags().StringVar(&optionFlavour, optionNameFlavour, "", "download only zim files of the given flavour (e.g. mini, nopic or maxi)")
+	cmd.Flags().StringVar(&optionZimName, optionNameZimName, "", "download only zim files whose name contains the given text")
+	cmd.Flags().BoolVar(&optionLatest, optionNameLatest, false, "download only the latest release of each zim file")
+	cmd.Flags().IntVar(&optionParallel, optionNameParallel, 2, "maximum number of concurrent downloads")
+
+	return cmd
+}
+
+// downloadAllFrom downloads all zim files listed in the kiwix mirror directory that match
+// the filter and returns their paths, including the ones already in the datadir.
+func downloadAllFrom(ctx context.Context, dataDir string, dirURL string, filter kiwix.Filter, latest bool, parallel int) ([]string, error) {
+	files, err := kiwix.FetchDirectory(ctx, dirURL)
+	if err != nil {
+		return nil, err
+	}
+
+	files = kiwix.FilterFiles(files, filter)
+	if latest {
+		files = kiwix.LatestFiles(files)
+	}
+	if len(files) == 0 {
+		log.Println("no zim files found for the given filter")
+		return nil, nil
+	}
+
+	var zimPaths, pending []string
+	var pendingURLs []string
+	for _, file := range files {
+		zimPath := filepath.Join(dataDir, file.Name)
+		zimPaths = append(zimPaths, zimPath)
+
+		// files are only moved to the datadir after being verified
+		if _, err := os.Stat(zimPath); err == nil {
+			log.Printf("Skipping %s: already downloaded", file.Name)
+			continue
+		}
+		pending = append(pending, zimPath)
+		pendingURLs = append(pendingURLs, file.URL)
+	}
+
+	if len(pending) == 0 {
+		return zimPaths, nil
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	header := fmt.Sprintf("Downloading %d zim files from %s", len(pending), dirURL)
+	pool := newProgressPool(newNetProgressBar(header, 0, true))
+	pool.Start()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(pending))
+	sem := make(chan struct{}, parallel)
+	for i := range pending {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := downloadZim(ctx, pendingURLs[i], pending[i], pool); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", filepath.Base(pending[i]), err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	pool.Stop()
+
+	var failed []string
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("%d of %d downloads failed:\n%s", len(failed), len(pending), strings.Join(failed, "\n"))
+	}
+	return zimPaths, nil
+}
+
+// checksumExtensions lists the checksum files published
+// by Kiwix next to each zim, in order of preference.
+var checksumExtensions = []string{".sha256", ".md5"}
+
+// verifyDownload verifies the downloaded file against the checksum
+// published next to it. A corrupted file is removed, so the next run
+// downloads it again from scratch.
+func verifyDownload(ctx context.Context, targetURL string, file string) error {
+	for _, ext := range checksumExtensions {
+		expected, err := fetchChecksum(ctx, targetURL+ext)
+		if errors.Is(err, errChecksumNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Verifying %s checksum of %s", strings.TrimPrefix(ext, "."), filepath.Base(file))
+		sum, err := fileChecksum(file, newChecksumHash(ext))
+		if err != nil {
+			return err
+		}
+
+		if sum != expected {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", targetURL, expected, sum)
+		}
+		return nil
+	}
+
+	log.Printf("No checksum found for %s, skipping verification", targetURL)
+	return nil
+}
+
+var errChecksumNotFound = errors.New("checksum not found")
+
+// fetchChecksum downloads a checksum file in the format
+// produced by sha256sum and md5sum, and returns its hash.
+// The request is retried and bounded by the timeout of the requests to bee.
+func fetchChecksum(ctx context.Context, checksumURL string) (string, error) {
+	u, err := url.Parse(checksumURL)
+	if err != nil {
+		return "", err
+	}
+	retry := requestRetryOptions()
+	c, err := httpclient.NewClient(&url.URL{Scheme: u.Scheme, Host: u.Host}, &httpclient.ClientOptions{
+		Retry:   &retry,
+		Timeout: optionRequestTimeout,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	body, err := c.RequestData(ctx, http.MethodGet, u.RequestURI(), nil)
+	if errors.Is(err, httpclient.ErrNotFound) {
+		return "", errChecksumNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("checksum download failed: %v: %v", checksumURL, err)
+	}
+	defer body.Close()
+
+	// checksum files are tiny, so anything bigger is not one
+	data, err := io.ReadAll(io.LimitReader(body, 1<<10))
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("invalid checksum file: %s", checksumURL)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+func newChecksumHash(ext string) hash.Hash {
+	if ext == ".md5" {
+		return md5.New()
+	}
+	return sha256.New()
+}
+
+// fileChecksum returns the hex encoded hash of the file.
+func fileChecksum(file string, h hash.Hash) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
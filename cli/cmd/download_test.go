@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testZimContent = bytes.Repeat([]byte("zim content "), 1000)
+
+// zimMirror serves the zim file at /test.zim with the given handler,
+// and its checksum files if they are set.
+type zimMirror struct {
+	zim    http.HandlerFunc
+	sha256 string
+	md5    string
+	// ranges lists the Range headers of the zim requests
+	ranges []string
+}
+
+func (m *zimMirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/test.zim":
+		m.ranges = append(m.ranges, r.Header.Get("Range"))
+		m.zim(w, r)
+	case "/test.zim.sha256":
+		serveChecksum(w, m.sha256)
+	case "/test.zim.md5":
+		serveChecksum(w, m.md5)
+	default:
+		http.NotFound(w, nil)
+	}
+}
+
+func serveChecksum(w http.ResponseWriter, sum string) {
+	if sum == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "%s  test.zim\n", sum)
+}
+
+// serveZim serves the zim content, supporting ranges.
+func serveZim(w http.ResponseWriter, r *http.Request) {
+	http.ServeContent(w, r, "test.zim", time.Time{}, bytes.NewReader(testZimContent))
+}
+
+func sha256Of(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func md5Of(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// downloadTestZim downloads the zim from the mirror to a directory holding
+// the given part file, if any, and returns the path of the zim file.
+func downloadTestZim(t *testing.T, m *zimMirror, part []byte) (string, error) {
+	t.Helper()
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	dst := filepath.Join(t.TempDir(), "test.zim")
+	if part != nil {
+		if err := os.WriteFile(dst+".part", part, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dst, downloadZim(context.Background(), srv.URL+"/test.zim", dst, nil)
+}
+
+func checkDownloadedZim(t *testing.T, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, testZimContent) {
+		t.Errorf("got %d bytes downloaded, want the %d bytes of the zim", len(data), len(testZimContent))
+	}
+	if _, err := os.Stat(dst + ".part"); !os.IsNotExist(err) {
+		t.Errorf("part file left after the download: %v", err)
+	}
+}
+
+func TestDownloadResumesPartFile(t *testing.T) {
+	m := &zimMirror{zim: serveZim, sha256: sha256Of(testZimContent)}
+	dst, err := downloadTestZim(t, m, testZimContent[:5000])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloadedZim(t, dst)
+	if len(m.ranges) != 1 || m.ranges[0] != "bytes=5000-" {
+		t.Errorf("got zim requests with ranges %q, want a single one from byte 5000", m.ranges)
+	}
+}
+
+func TestDownloadIgnoredRange(t *testing.T) {
+	m := &zimMirror{
+		zim: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", fmt.Sprint(len(testZimContent)))
+			w.Write(testZimContent)
+		},
+		sha256: sha256Of(testZimContent),
+	}
+	dst, err := downloadTestZim(t, m, testZimContent[:5000])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloadedZim(t, dst)
+}
+
+func TestDownloadRangeFromOtherByte(t *testing.T) {
+	m := &zimMirror{
+		zim: func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") == "" {
+				serveZim(w, r)
+				return
+			}
+			// the range is served from the start of the file
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(testZimContent)-1, len(testZimContent)))
+			w.Header().Set("Content-Length", fmt.Sprint(len(testZimContent)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(testZimContent)
+		},
+		sha256: sha256Of(testZimContent),
+	}
+	dst, err := downloadTestZim(t, m, testZimContent[:5000])
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloadedZim(t, dst)
+	if len(m.ranges) != 2 || m.ranges[1] != "" {
+		t.Errorf("got zim requests with ranges %q, want the download started over", m.ranges)
+	}
+}
+
+func TestDownloadCompletePartFile(t *testing.T) {
+	m := &zimMirror{zim: serveZim, sha256: sha256Of(testZimContent)}
+	dst, err := downloadTestZim(t, m, testZimContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloadedZim(t, dst)
+	if len(m.ranges) != 1 {
+		t.Errorf("got zim requests with ranges %q, want only the one answered by 416", m.ranges)
+	}
+}
+
+func TestDownloadPartFileLongerThanZim(t *testing.T) {
+	m := &zimMirror{zim: serveZim, sha256: sha256Of(testZimContent)}
+	part := append(append([]byte{}, testZimContent...), "garbage"...)
+	dst, err := downloadTestZim(t, m, part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloadedZim(t, dst)
+	if len(m.ranges) != 2 || m.ranges[1] != "" {
+		t.Errorf("got zim requests with ranges %q, want the download started over", m.ranges)
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	corrupted := append([]byte("corrupted"), testZimContent[9:]...)
+	for _, tc := range []struct {
+		name string
+		m    *zimMirror
+	}{
+		{"sha256", &zimMirror{zim: serveZim, sha256: sha256Of(corrupted), md5: md5Of(testZimContent)}},
+		{"md5", &zimMirror{zim: serveZim, md5: md5Of(corrupted)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst, err := downloadTestZim(t, tc.m, nil)
+			if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+				t.Fatalf("got error %v, want a checksum mismatch", err)
+			}
+			for _, p := range []string{dst, dst + ".part"} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("corrupted download left at %s: %v", p, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadWithoutChecksum(t *testing.T) {
+	dst, err := downloadTestZim(t, &zimMirror{zim: serveZim}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDownloadedZim(t, dst)
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, tc := range []struct {
+		value       string
+		start, size int64
+		err         bool
+	}{
+		{value: "bytes 100-199/200", start: 100, size: 200},
+		{value: "bytes 100-199/*", start: 100, size: -1},
+		{value: "bytes */200", start: -1, size: 200},
+		{value: "bytes 100-199", err: true},
+		{value: "items 0-1/2", err: true},
+		{value: "", err: true},
+	} {
+		start, size, err := parseContentRange(tc.value)
+		if (err != nil) != tc.err || start != tc.start || size != tc.size {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v", tc.value, start, size, err)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient/manifest"
+	"github.com/r0qs/beezim/internal/zimfile"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+// filesIndex is the list of the ZIM entries written by the indexer
+// to the collections parsed with the search enabled.
+const filesIndex = "files.json"
+
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Rebuild a zim file from a collection in swarm",
+		Long:  "\nDownloads the entries of the collection given by --ref listed in its files.json and writes them to the zim file given by --out,\nwith their namespaces, titles, MIME types and redirects, and the main page.\nOnly collections parsed with --enable-search have a files.json.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr, err := parseCollectionReference(optionReference)
+			if err != nil {
+				return err
+			}
+			if filepath.Ext(optionOutput) != ".zim" {
+				return fmt.Errorf("file must has .zim extention")
+			}
+
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
+			if err := exportZim(ctx, addr, optionOutput, optionParallel); err != nil {
+				return err
+			}
+			log.Printf("Collection %s exported to %s", addr, optionOutput)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&optionReference, optionNameReference, "", "reference of the collection to export")
+	cmd.Flags().StringVar(&optionOutput, optionNameOutput, "", "path of the zim file to write")
+	cmd.Flags().IntVar(&optionParallel, optionNameParallel, 8, "maximum number of concurrent downloads")
+	cmd.MarkFlagRequired(optionNameReference)
+	cmd.MarkFlagRequired(optionNameOutput)
+
+	return cmd
+}
+
+// exportEntry is an entry of the collection to write to the zim file.
+type exportEntry struct {
+	path  string
+	entry zimfile.Entry
+	// redirect is set for redirects, whose target is the path of the
+	// entry they point to, or empty if it is read from the redirect page
+	redirect bool
+	target   string
+}
+
+// exportZim writes the entries listed in the files.json of the collection to
+// the zim file, downloading at most parallel entries ahead of the writer.
+// The zim file is written to a ".part" file renamed once completed.
+func exportZim(ctx context.Context, addr swarm.Address, zimPath string, parallel int) error {
+	m := manifest.NewReader(bee, addr)
+
+	files, err := fetchFilesIndex(ctx, m, addr)
+	if err != nil {
+		return err
+	}
+	redirects, err := fetchRedirectsMap(ctx, m, addr)
+	if err != nil {
+		return err
+	}
+
+	var (
+		entries  []exportEntry
+		fullURLs = make(map[string]string, len(files))
+		mainPage string
+	)
+	for p, f := range files {
+		e, err := zimEntryOf(p, f.Metadata)
+		if err != nil {
+			log.Printf("Skipping %s: %v", p, err)
+			continue
+		}
+		fullURLs[p] = e.FullURL()
+		if f.Metadata.MainPage {
+			mainPage = e.FullURL()
+		}
+
+		if f.Metadata.Redirect {
+			target := f.Metadata.RedirectTarget
+			if target == "" {
+				target = redirects[p]
+			}
+			entries = append(entries, exportEntry{path: p, entry: e, redirect: true, target: target})
+			continue
+		}
+		entries = append(entries, exportEntry{path: p, entry: e})
+	}
+	if mainPage == "" {
+		if p := fetchMainPagePath(ctx, m, addr); fullURLs[p] != "" {
+			mainPage = fullURLs[p]
+		} else {
+			log.Printf("No main page found in %s", addr)
+		}
+	}
+	// the entries are written in order, so the clusters hold related entries
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+
+	partPath := zimPath + ".part"
+	f, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the content is kept next to the zim file until it is completed
+	w, err := zimfile.NewWriter(f, filepath.Dir(zimPath))
+	if err != nil {
+		return err
+	}
+	defer w.Abort()
+	w.SetMainPage(mainPage)
+
+	var redirectEntries []exportEntry
+	downloads := make([]exportEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.redirect && e.target != "" {
+			redirectEntries = append(redirectEntries, e)
+		} else {
+			downloads = append(downloads, e)
+		}
+	}
+
+	header := fmt.Sprintf("Exporting collection: %s", filepath.Base(zimPath))
+	progressBar := newNetProgressBar(header, len(entries), true)
+	progressBar.Start()
+	skipped, err := downloadExportEntries(ctx, m, downloads, parallel, func(e exportEntry, data []byte) error {
+		defer progressBar.Increment()
+		if !e.redirect {
+			return w.Add(e.entry, bytes.NewReader(data), int64(len(data)))
+		}
+		target, err := redirectPageTarget(e.path, data)
+		if err != nil {
+			log.Printf("Skipping redirect %s: %v", e.path, err)
+			return nil
+		}
+		e.target = target
+		redirectEntries = append(redirectEntries, e)
+		return nil
+	})
+	if err != nil {
+		progressBar.Finish()
+		return err
+	}
+
+	for _, e := range redirectEntries {
+		target, ok := fullURLs[e.target]
+		if !ok {
+			// the target is not an entry of the zim file,
+			// so the redirect is dropped by the writer
+			target = e.target
+		}
+		if err := w.AddRedirect(e.entry, target); err != nil {
+			progressBar.Finish()
+			return err
+		}
+		progressBar.Increment()
+	}
+	progressBar.SetCurrent(int64(len(entries)))
+	progressBar.Finish()
+
+	var missingTargets *zimfile.MissingTargetError
+	if err := w.Close(); errors.As(err, &missingTargets) {
+		log.Printf("Dropped %d redirects whose target is not in the collection", len(missingTargets.Redirects))
+	} else if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d entries of the %s missing from the collection", skipped, filesIndex)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partPath, zimPath)
+}
+
+// fetchFilesIndex downloads the list of the ZIM entries of the collection.
+func fetchFilesIndex(ctx context.Context, m *manifest.Reader, addr swarm.Address) (map[string]indexer.IndexEntry, error) {
+	// bee serves the error document for the paths without entry
+	if _, err := m.Lookup(ctx, filesIndex); errors.Is(err, manifest.ErrNotFound) {
+		return nil, fmt.Errorf("collection %s has no %s: only zim files parsed with --%s have one", addr, filesIndex, optionNameEnableSearch)
+	} else if err != nil {
+		return nil, err
+	}
+
+	data, err := bee.DownloadManifestBytes(ctx, addr, filesIndex)
+	if err != nil {
+		return nil, err
+	}
+	var files map[string]indexer.IndexEntry
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", filesIndex, err)
+	}
+	return files, nil
+}
+
+// fetchRedirectsMap downloads the redirects of the collection, if they were
+// resolved by its error page instead of written as redirect pages. They are
+// either split in the shards listed by the index of the redirects directory,
+// or in the single map of the mirrors parsed before the shards.
+func fetchRedirectsMap(ctx context.Context, m *manifest.Reader, addr swarm.Address) (map[string]string, error) {
+	indexPath := path.Join(indexer.RedirectsDir, indexer.RedirectsIndexFile)
+	data, err := fetchOptionalFile(ctx, m, addr, indexPath)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return fetchLegacyRedirectsMap(ctx, m, addr)
+	}
+	var index indexer.RedirectsIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", indexPath, err)
+	}
+
+	redirects := make(map[string]string)
+	for i := 0; i < index.Shards; i++ {
+		shardPath := indexer.RedirectShardPath(i)
+		data, err := bee.DownloadManifestBytes(ctx, addr, shardPath)
+		if err != nil {
+			return nil, fmt.Errorf("download %s: %v", shardPath, err)
+		}
+		var shard map[string]string
+		if err := json.Unmarshal(data, &shard); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", shardPath, err)
+		}
+		for from, to := range shard {
+			redirects[from] = to
+		}
+	}
+	return redirects, nil
+}
+
+// fetchLegacyRedirectsMap downloads the single redirects map of the mirrors
+// parsed before the map was split in shards, if any.
+func fetchLegacyRedirectsMap(ctx context.Context, m *manifest.Reader, addr swarm.Address) (map[string]string, error) {
+	data, err := fetchOptionalFile(ctx, m, addr, indexer.RedirectsMapFile)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var redirects map[string]string
+	if err := json.Unmarshal(data, &redirects); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", indexer.RedirectsMapFile, err)
+	}
+	return redirects, nil
+}
+
+// fetchOptionalFile downloads a file of the collection, or returns no data
+// if the collection has no such file.
+func fetchOptionalFile(ctx context.Context, m *manifest.Reader, addr swarm.Address, p string) ([]byte, error) {
+	if _, err := m.Lookup(ctx, p); errors.Is(err, manifest.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return bee.DownloadManifestBytes(ctx, addr, p)
+}
+
+// mainPageFrame matches the main page embedded in the index written by the
+// indexer when the search is enabled.
+var mainPageFrame = regexp.MustCompile(`<iframe name="iframe-zim" id="iframe-zim"[^>]* src="([^"]*)">`)
+
+// fetchMainPagePath returns the path of the main page embedded in the index
+// of the collection, for the mirrors parsed before it was flagged in the
+// files.json. It returns an empty path if it is not found.
+func fetchMainPagePath(ctx context.Context, m *manifest.Reader, addr swarm.Address) string {
+	if _, err := m.Lookup(ctx, "index.html"); err != nil {
+		return ""
+	}
+	data, err := bee.DownloadManifestBytes(ctx, addr, "index.html")
+	if err != nil {
+		return ""
+	}
+	match := mainPageFrame.FindSubmatch(data)
+	if match == nil {
+		return ""
+	}
+	return html.UnescapeString(string(match[1]))
+}
+
+// zimEntryOf returns the zim entry of a path of the collection. The mirrors
+// parsed before the namespace and url were recorded in the files.json have
+// the namespace as the first directory of the path.
+func zimEntryOf(p string, metadata indexer.IndexMetadata) (zimfile.Entry, error) {
+	e := zimfile.Entry{
+		Title:    metadata.Title,
+		MimeType: metadata.MimeType,
+	}
+	if len(metadata.Namespace) == 1 && metadata.URL != "" {
+		e.Namespace = metadata.Namespace[0]
+		e.URL = metadata.URL
+		return e, nil
+	}
+
+	i := strings.IndexByte(p, '/')
+	if i != 1 || len(p) < 3 {
+		return zimfile.Entry{}, fmt.Errorf("unknown namespace")
+	}
+	e.Namespace = p[0]
+	e.URL = p[2:]
+	return e, nil
+}
+
+// redirectMeta matches the target of the redirect pages written by the indexer.
+var redirectMeta = regexp.MustCompile(`<meta http-equiv="refresh" content="0; url=([^"]*)">`)
+
+// redirectPageTarget returns the path of the entry a redirect page points to,
+// which is in the same directory as the page.
+func redirectPageTarget(p string, page []byte) (string, error) {
+	match := redirectMeta.FindSubmatch(page)
+	if match == nil {
+		return "", fmt.Errorf("not a redirect page")
+	}
+	return path.Join(path.Dir(p), html.UnescapeString(string(match[1]))), nil
+}
+
+// exportDownload is the content of an entry downloaded for the export.
+type exportDownload struct {
+	data []byte
+	err  error
+}
+
+// downloadExportEntries downloads the entries with at most parallel downloads
+// ahead of the one being written, and passes them in order to write.
+// The entries are looked up in the manifest once and their content downloaded
+// by reference, since bee would serve the error page of the collection for
+// the missing ones, which are skipped and counted.
+func downloadExportEntries(ctx context.Context, m *manifest.Reader, entries []exportEntry, parallel int, write func(exportEntry, []byte) error) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	queue := make(chan chan exportDownload, parallel)
+	go func() {
+		defer close(queue)
+		for _, e := range entries {
+			c := make(chan exportDownload, 1)
+			select {
+			case queue <- c:
+			case <-ctx.Done():
+				return
+			}
+			go func(p string) {
+				data, err := downloadManifestEntry(ctx, m, p)
+				c <- exportDownload{data: data, err: err}
+			}(e.path)
+		}
+	}()
+
+	skipped := 0
+	for _, e := range entries {
+		c, ok := <-queue
+		if !ok {
+			return skipped, ctx.Err()
+		}
+		d := <-c
+		if errors.Is(d.err, manifest.ErrNotFound) {
+			skipped++
+			continue
+		}
+		if d.err != nil {
+			return skipped, d.err
+		}
+		if err := write(e, d.data); err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// downloadManifestEntry downloads the content of the entry of the manifest.
+func downloadManifestEntry(ctx context.Context, m *manifest.Reader, p string) ([]byte, error) {
+	e, err := m.Lookup(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	r, err := bee.DownloadBytes(ctx, e.Reference)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", p, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", p, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+
+	"github.com/ethersphere/bee/pkg/crypto"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// feedKeyFile is the name of the file holding the key that signs the feed updates.
+const feedKeyFile = "feed.key"
+
+// loadFeedSigner loads the hex encoded private key that owns the feeds.
+// A new key is generated on first use, since the feed manifests
+// only stay the same as long as the updates are signed by the same key.
+func loadFeedSigner(keyPath string) (crypto.Signer, error) {
+	if keyPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		keyPath = filepath.Join(home, ".beezim", feedKeyFile)
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := crypto.GenerateSecp256k1Key()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+			return nil, err
+		}
+		encoded := hex.EncodeToString(crypto.EncodeSecp256k1PrivateKey(key))
+		if err := os.WriteFile(keyPath, []byte(encoded+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		log.Printf("Created feed key at %s: keep it safe to keep updating the same feeds", keyPath)
+		return crypto.NewDefaultSigner(key), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed key %s: %v", keyPath, err)
+	}
+	key, err := crypto.DecodeSecp256k1PrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed key %s: %v", keyPath, err)
+	}
+	return crypto.NewDefaultSigner(key), nil
+}
+
+// feedOf returns the signer, owner and topic of the feed with the given name.
+func feedOf(name string) (crypto.Signer, []byte, []byte, error) {
+	signer, err := loadFeedSigner(optionFeedKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	owner, err := signer.EthereumAddress()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	topic, err := beeclient.FeedTopic(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return signer, owner.Bytes(), topic, nil
+}
+
+// publishFeed points the feed with the given name to the reference
+// and returns the feed manifest, whose url does not change between updates.
+func publishFeed(ctx context.Context, name string, ref swarm.Address, batchID string) (swarm.Address, error) {
+	signer, owner, topic, err := feedOf(name)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	opts := api.UploadOptions{
+		Pin:     optionBeePin,
+		BatchID: batchID,
+	}
+
+	index, err := bee.UpdateFeed(ctx, signer, topic, ref, opts)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	log.Printf("Feed %s of owner %x updated to %s at index %d", name, owner, ref, index)
+
+	return bee.CreateFeedManifest(ctx, owner, topic, opts)
+}
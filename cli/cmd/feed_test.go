@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/r0qs/beezim/catalog"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+
+	"github.com/ethersphere/bee/pkg/crypto"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// feedNode is a bee api keeping the single owner chunks uploaded
+// and resolving the sequential feeds made of them.
+type feedNode struct {
+	mu sync.Mutex
+	// payloads maps the owner and id of the single owner chunks to their payload
+	payloads map[string][]byte
+}
+
+func feedChunkKey(owner []byte, id []byte) string {
+	return hex.EncodeToString(owner) + "/" + hex.EncodeToString(id)
+}
+
+// feedIndexID returns the id of the update of the sequential feed at the index.
+func feedIndexID(topic []byte, index uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, index)
+	// hashing does not fail
+	id, _ := crypto.LegacyKeccak256(append(append([]byte{}, topic...), b...))
+	return id
+}
+
+func (n *feedNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	owner, _ := hex.DecodeString(parts[1])
+	id, _ := hex.DecodeString(parts[2])
+
+	switch {
+	case r.Method == http.MethodPost && parts[0] == "soc":
+		data, err := io.ReadAll(r.Body)
+		if err != nil || len(data) < swarm.SpanSize {
+			http.Error(w, `{"code":400,"message":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		n.payloads[feedChunkKey(owner, id)] = data[swarm.SpanSize:]
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"reference":"%064x"}`, len(n.payloads))
+	case r.Method == http.MethodGet && parts[0] == "feeds":
+		var index uint64
+		for ; ; index++ {
+			if _, ok := n.payloads[feedChunkKey(owner, feedIndexID(id, index))]; !ok {
+				break
+			}
+		}
+		if index == 0 {
+			http.Error(w, `{"code":404,"message":"not found"}`, http.StatusNotFound)
+			return
+		}
+		latest := feedIndexID(id, index-1)
+		w.Header().Set(api.SwarmFeedIndexHeader, fmt.Sprintf("%016x", index-1))
+		w.Header().Set(api.SwarmFeedIndexNextHeader, fmt.Sprintf("%016x", index))
+		fmt.Fprintf(w, `{"reference":"%x"}`, n.payloads[feedChunkKey(owner, latest)][8:])
+	case r.Method == http.MethodPost && parts[0] == "feeds":
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"reference":"%s"}`, sha256Of([]byte(parts[1]+parts[2])))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// withFeedNode points the bee client to the node and
+// signs the feed updates with a key in a temporary directory.
+func withFeedNode(t *testing.T, n *feedNode) {
+	t.Helper()
+	srv := httptest.NewServer(n)
+	t.Cleanup(srv.Close)
+
+	apiURL, keyPath, feed, retries, client := optionBeeApiUrl, optionFeedKey, optionFeed, optionRetries, bee
+	t.Cleanup(func() {
+		optionBeeApiUrl, optionFeedKey, optionFeed, optionRetries, bee = apiURL, keyPath, feed, retries, client
+	})
+
+	optionBeeApiUrl = srv.URL
+	optionFeedKey = filepath.Join(t.TempDir(), feedKeyFile)
+	optionRetries = 1
+	var err error
+	if bee, err = NewBeeClient(srv.URL, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// captureStdout returns what f prints.
+func captureStdout(t *testing.T, f func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = f()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestLoadFeedSigner(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), ".beezim", feedKeyFile)
+	signer, err := loadFeedSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v of the key, want it private", info.Mode().Perm())
+	}
+
+	// the key is kept, so the feeds keep their owner
+	again, err := loadFeedSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := signer.EthereumAddress()
+	sameOwner, _ := again.EthereumAddress()
+	if owner != sameOwner {
+		t.Errorf("got owner %x of the reloaded key, want %x", sameOwner, owner)
+	}
+
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFeedSigner(keyPath); err == nil {
+		t.Error("got no error loading an invalid key")
+	}
+}
+
+func TestMirrorFeedURL(t *testing.T) {
+	n := &feedNode{payloads: make(map[string][]byte)}
+	withFeedNode(t, n)
+	optionFeed = "wikipedia_en"
+	ctx := context.Background()
+
+	mirrors := []swarm.Address{
+		swarm.MustParseHexAddress(sha256Of([]byte("first mirror"))),
+		swarm.MustParseHexAddress(sha256Of([]byte("second mirror"))),
+	}
+	var feedLines []string
+	for _, addr := range mirrors {
+		out := captureStdout(t, func() error {
+			return publishMirror(ctx, addr, catalog.Entry{Zim: "wikipedia_en_all_maxi_2022-03.zim"}, "batch", "")
+		})
+		if !strings.Contains(out, "Try the link: "+makeURL(addr.String())) {
+			t.Errorf("got output %q, want the link of the mirror %s", out, addr)
+		}
+		var line string
+		for _, l := range strings.Split(out, "\n") {
+			if strings.HasPrefix(l, "Latest mirror of wikipedia_en: ") {
+				line = l
+			}
+		}
+		if line == "" {
+			t.Fatalf("got output %q, want the link of the feed", out)
+		}
+		feedLines = append(feedLines, line)
+	}
+	if feedLines[0] != feedLines[1] {
+		t.Errorf("got feed links %q and %q, want the same link for both mirrors", feedLines[0], feedLines[1])
+	}
+
+	signer, err := loadFeedSigner(optionFeedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := signer.EthereumAddress()
+	topic, _ := crypto.LegacyKeccak256([]byte("wikipedia_en"))
+	for i, addr := range mirrors {
+		payload, ok := n.payloads[feedChunkKey(owner.Bytes(), feedIndexID(topic, uint64(i)))]
+		if !ok || !bytes.Equal(payload[8:], addr.Bytes()) {
+			t.Errorf("got update %d %x of the feed, want it pointing to %s", i, payload, addr)
+		}
+	}
+}
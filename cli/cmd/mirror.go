@@ -4,8 +4,17 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"runtime"
+	"time"
 
+	"github.com/r0qs/beezim/catalog"
+	"github.com/r0qs/beezim/indexer"
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/registry"
+
+	"github.com/ethersphere/bee/pkg/swarm"
 	"github.com/spf13/cobra"
 )
 
@@ -14,33 +23,236 @@ func newMirrorCmd() *cobra.Command {
 		Use:   "mirror",
 		Short: "Mirror zim files to swarm",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			zimPath, err := download(optionDataDir, optionZimFile, optionZimURL)
+			zimPath, err := download(cmd.Context(), optionDataDir, optionZimFile, optionZimURL)
 			if err != nil {
 				return err
 			}
 
-			zimFile := filepath.Base(zimPath)
-			err = parse(optionDataDir, zimFile)
+			zimSum, err := zimChecksum(zimPath)
 			if err != nil {
 				return err
 			}
 
+			if !optionForce {
+				published, err := findMirror(zimSum)
+				if err != nil {
+					return err
+				}
+				if published != nil {
+					log.Printf("%s was already mirrored on %s with reference: %s", published.Zim, published.Timestamp.Local().Format(time.RFC3339), published.Reference)
+					log.Printf("Use --%s to mirror it again", optionNameForce)
+					fmt.Printf("\nTry the link: %s\n", makeURL(published.Reference))
+					return nil
+				}
+			}
+
+			// each mirror is added to the catalog unless --no-catalog is given,
+			// and the reference of an encrypted mirror is kept private
+			catalogFeed := optionCatalogFeed
+			if optionNoCatalog {
+				catalogFeed = ""
+			}
+			if optionEncrypt && catalogFeed != "" {
+				log.Printf("Not adding the encrypted mirror to the catalog %s", catalogFeed)
+				catalogFeed = ""
+			}
+
+			var baseAddr swarm.Address
+			if optionBase != "" {
+				if baseAddr, err = resolveBaseMirror(optionBase, optionEncrypt); err != nil {
+					return err
+				}
+				if !optionEnableSearch {
+					log.Printf("Without --%s the new mirror has no %s, so it cannot be the base of the next one", optionNameEnableSearch, filesIndex)
+				}
+			}
+
+			// read before the zim is removed by --clean
+			var entry catalog.Entry
+			if catalogFeed != "" {
+				if entry, err = catalogEntry(zimPath); err != nil {
+					return err
+				}
+			}
+
 			ctx, cancel := context.WithCancel(cmd.Context())
 			defer cancel()
 
+			batchID := optionBeeBatchID
+			var archiveOf func(string) (*indexer.Archive, error)
+			if optionIncludeZim {
+				if !optionEnableSearch {
+					log.Printf("The zim file is only linked from the about page with --%s", optionNameEnableSearch)
+				}
+				// the zim is uploaded before the about page linking it is made,
+				// with a batch sized for both the zim and the tar
+				archiveOf = func(tarPath string) (*indexer.Archive, error) {
+					id, err := postageBatchFor(ctx, batchID, tarPath, optionEncrypt, zimPath)
+					if err != nil {
+						return nil, err
+					}
+					batchID = id
+					return uploadZim(ctx, zimPath, zimSum, batchID)
+				}
+			}
+
+			zimFile := filepath.Base(zimPath)
 			ext := filepath.Ext(zimFile)
 			tarFile := fmt.Sprintf("%s.tar", zimFile[:len(zimFile)-len(ext)])
-			addr, err := upload(ctx, optionDataDir, tarFile, optionBeeBatchID)
+			tarPath := filepath.Join(optionDataDir, tarFile)
+
+			// parsing the zim again would rewrite the tar file and
+			// invalidate the checkpoint of its upload
+			if (optionResume || optionBase != "") && hasCheckpoint(tarPath, optionEncrypt) {
+				log.Printf("Resuming the upload of %s parsed by a previous run: remove %s to parse %s again", tarFile, tarFile+checkpointSuffix, zimFile)
+			} else if err := parse(optionDataDir, zimFile, archiveOf); err != nil {
+				return err
+			}
+
+			var base *baseMirror
+			if !baseAddr.IsZero() {
+				if base, err = diffBaseMirror(ctx, tarPath, baseAddr); err != nil {
+					return err
+				}
+				log.Printf("%d of %d entries are unchanged since the base mirror %s, uploading the other %d", len(base.reused), base.entries, baseAddr, base.entries-len(base.reused))
+			}
+
+			// the batch is resolved here since the feed update is stamped with it too
+			if base != nil && batchID == "" {
+				batchID, err = buyPostageBatchOfSize(ctx, tarFile, base.uploadSize, optionEncrypt)
+			} else {
+				batchID, err = postageBatchFor(ctx, batchID, tarPath, optionEncrypt)
+			}
+			if err != nil {
+				return err
+			}
+
+			addr, err := upload(ctx, optionDataDir, tarFile, batchID, zimSum, base)
 			if err != nil {
 				return err
 			}
 			log.Printf("collection %v uploaded with reference: %v", tarFile, addr)
-			fmt.Printf("\nTry the link: %s\n", makeURL(addr.String()))
-			return nil
+			return publishMirror(ctx, addr, entry, batchID, catalogFeed)
 		},
 	}
 	cmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "path to the zim file")
 	cmd.Flags().StringVar(&optionZimURL, optionNameZimURL, "", "download URL for the zim files")
+	cmd.Flags().IntVar(&optionParseWorkers, optionNameParseWorkers, runtime.NumCPU(), "number of workers decompressing the zim entries concurrently")
+	cmd.Flags().IntVar(&optionPrefetchMB, optionNamePrefetchMB, indexer.DefaultPrefetchBytes>>20, "megabytes of zim entries the workers decompress ahead of the writer")
+	cmd.Flags().StringSliceVar(&optionIncludeNS, optionNameIncludeNS, nil, "comma-separated list of zim namespaces to include in the output (e.g. M,X)")
+	cmd.Flags().StringSliceVar(&optionExcludeNS, optionNameExcludeNS, nil, "comma-separated list of zim namespaces to exclude from the output (e.g. I); the content of zims of the new namespace scheme is sorted into A, I, - and J by MIME type")
+	cmd.Flags().StringSliceVar(&optionRemapNS, optionNameRemapNS, nil, "comma-separated list of zim namespaces written under another path prefix, as namespace=prefix (e.g. I=media)")
+	cmd.Flags().StringVar(&optionRedirectMode, optionNameRedirectMode, string(indexer.RedirectHTML), "how zim redirects are written: \"html\" pages or a \"map\" resolved by the error page")
+	cmd.Flags().BoolVar(&optionForce, optionNameForce, false, "mirror the zim even if it was already published")
+	cmd.Flags().BoolVar(&optionEncrypt, optionNameEncrypt, false, "encrypt the mirror, which is only readable with its 64 bytes reference and is not added to the catalog")
+	cmd.Flags().BoolVar(&optionResume, optionNameResume, false, "upload the tar file entry by entry, resuming from the checkpoint of a previous run")
+	cmd.Flags().IntVar(&optionUploadWorkers, optionNameUploadWorkers, 8, "number of entries uploaded concurrently with --resume")
+	cmd.Flags().StringVar(&optionBase, optionNameBase, "", "reference, zim or tar name of a previous mirror whose unchanged entries are reused instead of uploaded")
+	cmd.Flags().BoolVar(&optionIncludeZim, optionNameIncludeZim, false, "also upload the zim file, linked from the about page of the mirror")
+	cmd.Flags().StringVar(&optionFeed, optionNameFeed, "", "name of a feed to point to the new mirror (e.g. wikipedia_en)")
+	cmd.Flags().StringVar(&optionCatalogFeed, optionNameCatalogFeed, defaultCatalogFeed, "name of the feed of the catalog of mirrors the mirror is added to")
+	cmd.Flags().BoolVar(&optionNoCatalog, optionNameNoCatalog, false, "do not add the mirror to the catalog of mirrors")
+	cmd.Flags().StringVar(&optionFeedKey, optionNameFeedKey, "", "path to the hex private key signing the feed updates (default \"~/.beezim/feed.key\")")
 
 	return cmd
 }
+
+// uploadZim uploads the zim file as bytes, streamed from the disk so the
+// upload can be retried, and returns the archive linked from the mirror.
+func uploadZim(ctx context.Context, zimPath string, zimSum string, batchID string) (*indexer.Archive, error) {
+	name := filepath.Base(zimPath)
+	f, err := os.Open(zimPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPostageBatchCapacity(ctx, batchID, info.Size(), optionEncrypt); err != nil {
+		return nil, err
+	}
+
+	opts := api.UploadOptions{
+		Pin:     optionBeePin,
+		Tag:     optionBeeTag,
+		BatchID: batchID,
+		Encrypt: optionEncrypt,
+	}
+	if opts.Tag == 0 {
+		if opts.Tag, err = bee.CreateTag(ctx); err != nil {
+			log.Printf("Not tracking the sync of %s: %v", name, err)
+		}
+	}
+
+	header := fmt.Sprintf("Uploading zim file: %s", name)
+	progressBar := newNetProgressBar(header, int(info.Size()), true)
+	progressBar.Start()
+	addr, err := bee.UploadBytes(ctx, &progressReadSeeker{ReadSeeker: f, bar: progressBar}, opts)
+	progressBar.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("upload zim file %s: %v", name, err)
+	}
+	log.Printf("zim file %v uploaded with reference: %v", name, addr)
+
+	if opts.Tag != 0 {
+		if err := trackSync(ctx, name, opts.Tag); err != nil {
+			return nil, err
+		}
+	}
+	return &indexer.Archive{
+		Name:      name,
+		Reference: addr.String(),
+		Size:      info.Size(),
+		Checksum:  zimSum,
+	}, nil
+}
+
+// publishMirror points the feed given by --feed and the catalog feed to the
+// uploaded mirror, and prints their links, which do not change between mirrors.
+func publishMirror(ctx context.Context, addr swarm.Address, entry catalog.Entry, batchID string, catalogFeed string) error {
+	entry.Reference = addr.String()
+
+	if optionFeed != "" {
+		manifest, err := publishFeed(ctx, optionFeed, addr, batchID)
+		if err != nil {
+			return err
+		}
+		log.Printf("feed %v manifest reference: %v", optionFeed, manifest)
+		entry.Feed = manifest.String()
+	}
+
+	var catalogManifest swarm.Address
+	if catalogFeed != "" {
+		var err error
+		catalogManifest, err = updateCatalog(ctx, catalogFeed, entry, batchID)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\nTry the link: %s\n", makeURL(addr.String()))
+	if optionFeed != "" {
+		fmt.Printf("Latest mirror of %s: %s\n", optionFeed, makeURL(entry.Feed))
+	}
+	if catalogFeed != "" {
+		fmt.Printf("Catalog of all mirrors: %s\n", makeURL(catalogManifest.String()))
+	}
+	return nil
+}
+
+// findMirror returns the registry record of a zim already mirrored, if any.
+func findMirror(zimSum string) (*registry.Record, error) {
+	reg, err := openRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	rec, ok := reg.FindByZimChecksum(zimSum, optionEncrypt)
+	if !ok {
+		return nil, nil
+	}
+	return &rec, nil
+}
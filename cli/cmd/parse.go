@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/r0qs/beezim/indexer"
@@ -20,32 +21,60 @@ func newParserCmd() *cobra.Command {
 				if filepath.Ext(optionZimFile) != ".zim" {
 					return fmt.Errorf("file must has .zim extention")
 				}
-				return parse(optionDataDir, optionZimFile)
+				return parse(optionDataDir, optionZimFile, nil)
 			}
 			return fmt.Errorf("zim file not provided")
 		},
 	}
 	cmd.Flags().StringVar(&optionZimFile, optionNameZimFile, "", "path to the zim file")
 	cmd.Flags().BoolVar(&optionExtractOnly, optionNameExtractOnly, false, "parse and extract the zim file to the datadir")
+	cmd.Flags().IntVar(&optionParseWorkers, optionNameParseWorkers, runtime.NumCPU(), "number of workers decompressing the zim entries concurrently")
+	cmd.Flags().IntVar(&optionPrefetchMB, optionNamePrefetchMB, indexer.DefaultPrefetchBytes>>20, "megabytes of zim entries the workers decompress ahead of the writer")
+	cmd.Flags().StringSliceVar(&optionIncludeNS, optionNameIncludeNS, nil, "comma-separated list of zim namespaces to include in the output (e.g. M,X)")
+	cmd.Flags().StringSliceVar(&optionExcludeNS, optionNameExcludeNS, nil, "comma-separated list of zim namespaces to exclude from the output (e.g. I); the content of zims of the new namespace scheme is sorted into A, I, - and J by MIME type")
+	cmd.Flags().StringSliceVar(&optionRemapNS, optionNameRemapNS, nil, "comma-separated list of zim namespaces written under another path prefix, as namespace=prefix (e.g. I=media)")
+	cmd.Flags().StringVar(&optionRedirectMode, optionNameRedirectMode, string(indexer.RedirectHTML), "how zim redirects are written: \"html\" pages or a \"map\" resolved by the error page")
 
 	return cmd
 }
 
-func parse(dataDir string, zimFile string) error {
+// parse parses the zim file into a tar file in the datadir, or extracts it
+// with --extract-only. If archiveOf is given, it is called with the path of
+// the tar file once the articles are written, and the archive it returns
+// is linked from the about page.
+func parse(dataDir string, zimFile string, archiveOf func(tarFile string) (*indexer.Archive, error)) error {
 	zimPath := filepath.Join(dataDir, zimFile)
 	dirName := strings.TrimSuffix(filepath.Base(zimPath), ".zim")
 
-	sidx, err := indexer.New(zimPath, optionEnableSearch)
+	policy, err := namespacePolicy()
 	if err != nil {
 		return err
 	}
 
+	redirectMode, err := indexer.ParseRedirectMode(optionRedirectMode)
+	if err != nil {
+		return err
+	}
+
+	sidx, err := indexer.New(zimPath, indexer.Options{
+		Policy:        policy,
+		Workers:       optionParseWorkers,
+		PrefetchBytes: int64(optionPrefetchMB) << 20,
+		RedirectMode:  redirectMode,
+	})
+	if err != nil {
+		return err
+	}
+	defer sidx.Close()
+
 	// Parse zim file
 	zimArticles := sidx.ParseZIM()
 
 	if optionExtractOnly {
 		outputDir := filepath.Join(optionDataDir, dirName)
-		return sidx.UnZim(outputDir, zimArticles)
+		if err := sidx.UnZim(outputDir, zimArticles); err != nil {
+			return err
+		}
 	} else {
 		// TODO: what should be the default policy? check if file already exists and
 		// do not build the tar, or overwrite it everytime?
@@ -55,6 +84,14 @@ func parse(dataDir string, zimFile string) error {
 			return err
 		}
 
+		if archiveOf != nil {
+			archive, err := archiveOf(tarFile)
+			if err != nil {
+				return err
+			}
+			sidx.SetArchive(archive)
+		}
+
 		if optionEnableSearch {
 			// Append index page with search tool
 			if err := sidx.MakeIndexSearchPage(tarFile); err != nil {
@@ -72,11 +109,62 @@ func parse(dataDir string, zimFile string) error {
 			}
 		}
 
+		// Append redirects resolved by the 404 page
+		if err := sidx.MakeRedirectsMap(tarFile); err != nil {
+			return fmt.Errorf("Failed to copy the %s map to tar file: %v", indexer.RedirectsDir, err)
+		}
+
 		// Append 404 page
 		if err := sidx.MakeErrorPage(tarFile); err != nil {
 			return fmt.Errorf("Failed to copy error.html page to tar file: %v", err)
 		}
 	}
 
+	printParseSummary(sidx)
 	return nil
 }
+
+// printParseSummary prints how many entries were extracted and how many
+// failed, so users can decide if the mirror is good enough to be published.
+func printParseSummary(sidx *indexer.SwarmZimIndexer) {
+	exceptions := sidx.Exceptions()
+	// the redirects of the map are indexed without being written
+	mapped := sidx.MappedRedirects()
+	extracted := len(sidx.Entries()) - mapped
+	if mapped > 0 {
+		fmt.Printf("\nParsed %s: %d entries extracted, %d redirects mapped, %d failed\n", filepath.Base(sidx.ZimPath), extracted, mapped, len(exceptions))
+	} else {
+		fmt.Printf("\nParsed %s: %d entries extracted, %d failed\n", filepath.Base(sidx.ZimPath), extracted, len(exceptions))
+	}
+	if len(exceptions) > 0 {
+		fmt.Printf("Failed entries are listed in %s/errors.json\n", indexer.ExceptionsDir)
+	}
+}
+
+// namespacePolicy builds the namespace policy from the default one,
+// including, remapping and excluding the namespaces requested by the user.
+func namespacePolicy() (*indexer.NamespacePolicy, error) {
+	policy := indexer.DefaultNamespacePolicy(optionEnableSearch)
+
+	include, err := indexer.ParseNamespaces(optionIncludeNS)
+	if err != nil {
+		return nil, err
+	}
+	policy.Include(include...)
+
+	remaps, err := indexer.ParseNamespaceRemaps(optionRemapNS)
+	if err != nil {
+		return nil, err
+	}
+	for ns, prefix := range remaps {
+		policy.Remap(ns, prefix)
+	}
+
+	exclude, err := indexer.ParseNamespaces(optionExcludeNS)
+	if err != nil {
+		return nil, err
+	}
+	policy.Skip(exclude...)
+
+	return policy, nil
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/beeclient/debugapi"
+	"github.com/r0qs/beezim/internal/httpclient"
+)
+
+// postageBatchPollInterval is how often the node is asked
+// whether a newly bought batch is already usable.
+var postageBatchPollInterval = 5 * time.Second
+
+// postageBatchFor returns the batch used to stamp the upload of the tar file
+// and of the other given files. If no batch ID was given, a new batch sized
+// for all of them, encrypted or not, is bought.
+func postageBatchFor(ctx context.Context, batchID string, tarPath string, encrypt bool, others ...string) (string, error) {
+	if batchID != "" {
+		return batchID, nil
+	}
+	return buyPostageBatch(ctx, tarPath, encrypt, others...)
+}
+
+// buyPostageBatch buys a batch able to stamp all the chunks
+// of the tar file and of the other files.
+func buyPostageBatch(ctx context.Context, tarPath string, encrypt bool, others ...string) (string, error) {
+	var size int64
+	for _, p := range append([]string{tarPath}, others...) {
+		info, err := os.Stat(p)
+		if err != nil {
+			return "", err
+		}
+		size += info.Size()
+	}
+	return buyPostageBatchOfSize(ctx, filepath.Base(tarPath), size, encrypt)
+}
+
+// buyPostageBatchOfSize estimates the depth and amount of a batch able to
+// stamp size bytes of the named upload for the requested TTL and, once the
+// user agrees with its cost, buys it and waits until it is usable.
+func buyPostageBatchOfSize(ctx context.Context, name string, size int64, encrypt bool) (string, error) {
+	if err := requireDebugAPI(); err != nil {
+		return "", fmt.Errorf("a postage batch is required: provide --%s or buy one: %v", optionNameBeeBatchID, err)
+	}
+
+	depth, chunks := beeclient.EstimatePostageBatchDepth(size, encrypt)
+	if optionBeeBatchDepth != 0 {
+		depth = optionBeeBatchDepth
+	}
+	if depth < beeclient.MinimumBatchDepth {
+		depth = beeclient.MinimumBatchDepth
+	}
+
+	state, err := bee.ChainState(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get postage price: %v", err)
+	}
+
+	amount := optionBeeBatchAmount
+	if amount == 0 {
+		amount = beeclient.EstimatePostageBatchAmount(optionBeeBatchTTL, state.CurrentPrice.Int)
+	}
+	ttl := beeclient.PostageBatchTTL(amount, state.CurrentPrice.Int)
+	cost := beeclient.PostageBatchCost(amount, depth)
+
+	fmt.Printf("Uploading %s requires about %d chunks.\n", name, chunks)
+	fmt.Printf("A postage batch of depth %d and amount %d lasts about %v and costs %s BZZ.\n", depth, amount, ttl, cost.Text('f', 16))
+
+	var newBatchID string
+	action := fmt.Sprintf("Buy the postage batch for %s?", name)
+	confirmationReader := NewConfirmationInputReader(action, func() error {
+		label := strings.TrimSuffix(name, filepath.Ext(name))
+		newBatchID, err = bee.CreatePostageBatch(ctx, amount, depth, label, debugapi.PostageOptions{
+			GasPrice: optionGasPrice,
+		})
+		return err
+	})
+
+	if _, err := confirmationReader.ReadInput(); err != nil {
+		if err == AbortCmd {
+			return "", err
+		}
+		return "", fmt.Errorf("buy postage batch: %v", err)
+	}
+	if newBatchID == "" {
+		return "", fmt.Errorf("postage batch purchase declined: cannot upload %s", name)
+	}
+
+	log.Printf("Bought postage batch %s, waiting until it is usable...", newBatchID)
+	if err := bee.WaitPostageBatchUsable(ctx, newBatchID, postageBatchPollInterval); err != nil {
+		return "", err
+	}
+	log.Printf("Postage batch %s is usable", newBatchID)
+	return newBatchID, nil
+}
+
+// checkPostageBatchCapacity refuses to upload content that would overflow the
+// buckets of the batch, suggesting the depth to dilute the batch to.
+// The check is skipped when the bee debug api is not available.
+func checkPostageBatchCapacity(ctx context.Context, batchID string, size int64, encrypt bool) error {
+	if requireDebugAPI() != nil {
+		log.Printf("Skipping capacity check of postage batch %s: no bee debug api", batchID)
+		return nil
+	}
+
+	batch, err := bee.PostageBatch(ctx, batchID)
+	if err != nil {
+		if errors.Is(err, httpclient.ErrNotFound) {
+			return fmt.Errorf("postage batch %s not found", batchID)
+		}
+		return fmt.Errorf("get postage batch %s: %v", batchID, err)
+	}
+
+	err = beeclient.CheckPostageBatchCapacity(batch, size, encrypt)
+	var capacityErr *beeclient.BatchCapacityError
+	if errors.As(err, &capacityErr) {
+		return fmt.Errorf("%v.\nDilute the batch with \"stamps dilute %s %d\" or use another batch", err, batchID, capacityErr.SuggestedDepth)
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient"
+)
+
+const testBatchID = "36b7efd913ca4cf880b8eeac5093fa27b0825906c600685b6abdd6566e6cfe8f"
+
+// postageNode is a bee debug api selling a batch, which becomes usable after
+// being unknown and then not usable on the first polls.
+type postageNode struct {
+	mu    sync.Mutex
+	price int64
+	// bought lists the paths of the buy requests
+	bought []string
+	polls  int
+}
+
+func (n *postageNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/chainstate":
+		fmt.Fprintf(w, `{"block":1,"totalAmount":"0","currentPrice":"%d"}`, n.price)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/stamps/"):
+		n.bought = append(n.bought, r.URL.RequestURI())
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"batchID":%q}`, testBatchID)
+	case r.Method == http.MethodGet && r.URL.Path == "/stamps/"+testBatchID:
+		n.polls++
+		if n.polls == 1 {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code":404,"message":"issuer does not exist"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"batchID":     testBatchID,
+			"usable":      n.polls > 2,
+			"depth":       17,
+			"bucketDepth": 16,
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// withPostageNode points the bee client to the node and answers the
+// confirmation prompt with the given answer.
+func withPostageNode(t *testing.T, n *postageNode, answer string) {
+	t.Helper()
+	srv := httptest.NewServer(n)
+	t.Cleanup(srv.Close)
+
+	stdin, debugURL, interval, retries := os.Stdin, optionBeeDebugApiUrl, postageBatchPollInterval, optionRetries
+	depth, amount, ttl := optionBeeBatchDepth, optionBeeBatchAmount, optionBeeBatchTTL
+	client := bee
+	t.Cleanup(func() {
+		os.Stdin, optionBeeDebugApiUrl, postageBatchPollInterval, optionRetries = stdin, debugURL, interval, retries
+		optionBeeBatchDepth, optionBeeBatchAmount, optionBeeBatchTTL = depth, amount, ttl
+		bee = client
+	})
+
+	optionBeeDebugApiUrl = srv.URL
+	postageBatchPollInterval = time.Millisecond
+	optionRetries = 1
+	optionBeeBatchDepth, optionBeeBatchAmount, optionBeeBatchTTL = 0, 0, 24*time.Hour
+	var err error
+	if bee, err = NewBeeClient(srv.URL, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	fmt.Fprintln(w, answer)
+	w.Close()
+	os.Stdin = r
+}
+
+func TestPostageBatchForBuysUsableBatch(t *testing.T) {
+	n := &postageNode{price: 24000}
+	withPostageNode(t, n, "y")
+
+	tarPath := filepath.Join(t.TempDir(), "wikipedia_en.tar")
+	if err := os.WriteFile(tarPath, make([]byte, 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	batchID, err := postageBatchFor(context.Background(), "", tarPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batchID != testBatchID {
+		t.Errorf("got batch %s, want %s", batchID, testBatchID)
+	}
+
+	depth, _ := beeclient.EstimatePostageBatchDepth(1<<20, false)
+	amount := beeclient.EstimatePostageBatchAmount(24*time.Hour, big.NewInt(n.price))
+	want := fmt.Sprintf("/stamps/%d/%d?label=wikipedia_en", amount, depth)
+	if len(n.bought) != 1 || n.bought[0] != want {
+		t.Errorf("got buy requests %v, want %s", n.bought, want)
+	}
+	if n.polls != 3 {
+		t.Errorf("got %d polls of the batch, want it to be polled until usable", n.polls)
+	}
+}
+
+func TestPostageBatchForDeclined(t *testing.T) {
+	n := &postageNode{price: 24000}
+	withPostageNode(t, n, "n")
+
+	if _, err := buyPostageBatchOfSize(context.Background(), "wikipedia_en.tar", 1<<20, false); err == nil {
+		t.Error("got no error for a declined purchase")
+	}
+	if len(n.bought) != 0 || n.polls != 0 {
+		t.Errorf("got %d buy requests and %d polls for a declined purchase, want none", len(n.bought), n.polls)
+	}
+}
+
+func TestPostageBatchForGivenBatch(t *testing.T) {
+	n := &postageNode{price: 24000}
+	withPostageNode(t, n, "y")
+
+	batchID, err := postageBatchFor(context.Background(), testBatchID, "missing.tar", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batchID != testBatchID || len(n.bought) != 0 {
+		t.Errorf("got batch %s and buy requests %v, want the given batch and no purchase", batchID, n.bought)
+	}
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	pb "github.com/cheggaaa/pb/v3"
+)
+
+// progressReadSeeker reports the bytes read from a seekable request body to
+// a progress bar, which goes back with the body when the request is retried.
+type progressReadSeeker struct {
+	io.ReadSeeker
+	bar *pb.ProgressBar
+}
+
+func (r *progressReadSeeker) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.bar.Add(n)
+	return n, err
+}
+
+func (r *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		r.bar.SetCurrent(n)
+	}
+	return n, err
+}
+
+// progressPool renders several progress bars together, one per line,
+// below an aggregated bar that tracks the progress of all of them.
+// While running, log messages are printed above the bars.
+type progressPool struct {
+	mu     sync.Mutex
+	w      io.Writer
+	total  *pb.ProgressBar
+	bars   []*pb.ProgressBar
+	lines  int
+	logOut io.Writer
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newProgressPool(total *pb.ProgressBar) *progressPool {
+	total.Set(pb.Static, true)
+	return &progressPool{
+		w:     os.Stderr,
+		total: total,
+		done:  make(chan struct{}),
+	}
+}
+
+// Add adds a bar to the pool. The bar is only rendered by the pool.
+func (p *progressPool) Add(bar *pb.ProgressBar) {
+	bar.Set(pb.Static, true)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bars = append(p.bars, bar)
+}
+
+// Track adds the bar to the pool and returns a reader that also
+// reports the progress of the size bytes read from r to the aggregated bar.
+func (p *progressPool) Track(bar *pb.ProgressBar, r io.Reader, size int64) io.Reader {
+	p.Add(bar)
+	p.total.AddTotal(size)
+	return p.total.NewProxyReader(r)
+}
+
+// Start starts rendering the bars until Stop is called.
+func (p *progressPool) Start() {
+	p.total.Start()
+	p.logOut = log.Writer()
+	log.SetOutput(p)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.Lock()
+				p.render()
+				p.mu.Unlock()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops rendering and prints the final state of the bars.
+func (p *progressPool) Stop() {
+	close(p.done)
+	p.wg.Wait()
+
+	p.total.Finish()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.render()
+	log.SetOutput(p.logOut)
+}
+
+// Write prints a log message above the bars.
+func (p *progressPool) Write(msg []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+	n, err := p.w.Write(msg)
+	p.render()
+	return n, err
+}
+
+// clear moves the cursor to the first bar and erases all the bars.
+func (p *progressPool) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.w, "\033[%dA\033[J", p.lines)
+	}
+	p.lines = 0
+}
+
+func (p *progressPool) render() {
+	p.clear()
+	for _, bar := range append([]*pb.ProgressBar{p.total}, p.bars...) {
+		fmt.Fprintf(p.w, "%s\033[K\n", bar.String())
+		p.lines++
+	}
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/registry"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+func newRegistryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "registry",
+		Short: "Manage the local registry of published mirrors",
+		Long:  "\nEvery upload is recorded in a local registry with its swarm reference, postage batch and the checksums of its zim and tar files.",
+	}
+	cmd.AddCommand(
+		newRegistryListCmd(),
+		newRegistryShowCmd(),
+		newRegistryForgetCmd(),
+	)
+
+	return cmd
+}
+
+// Registry Subcommands
+func newRegistryListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the published mirrors",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := openRegistry()
+			if err != nil {
+				return err
+			}
+			return printRecords(reg.Records())
+		},
+	}
+	cmd.Flags().BoolVar(&optionJSON, optionNameJSON, false, "print the records as JSON")
+
+	return cmd
+}
+
+func newRegistryShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show <reference|zim|tar>",
+		Short: "Show the records of a published mirror",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := openRegistry()
+			if err != nil {
+				return err
+			}
+
+			records := reg.Find(args[0])
+			if len(records) == 0 {
+				return fmt.Errorf("no records found for %s", args[0])
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(records)
+		},
+	}
+
+	return cmd
+}
+
+func newRegistryForgetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "forget <reference|zim|tar>",
+		Short: "Remove the records of a published mirror",
+		Long:  "\nRemoves the records of a mirror from the registry, so it can be mirrored again without --force.\nThe content is not removed from swarm.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reg, err := openRegistry()
+			if err != nil {
+				return err
+			}
+
+			n, err := reg.Forget(args[0])
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return fmt.Errorf("no records found for %s", args[0])
+			}
+			log.Printf("Removed %d records of %s from the registry", n, args[0])
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+// openRegistry opens the registry given by --registry or the default one.
+func openRegistry() (*registry.Registry, error) {
+	path := optionRegistryPath
+	if path == "" {
+		var err error
+		if path, err = registry.DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+	return registry.Open(path)
+}
+
+// zimChecksum returns the sha256 checksum identifying a zim in the registry.
+func zimChecksum(zimPath string) (string, error) {
+	return fileChecksum(zimPath, sha256.New())
+}
+
+// recordUpload adds the uploaded tar to the registry. The zim it was built
+// from is looked up in the same directory if its checksum is not given.
+// Failures are only logged, since the upload itself succeeded.
+func recordUpload(tarPath string, zimSum string, addr swarm.Address, opts api.UploadCollectionOptions) {
+	tarFile := filepath.Base(tarPath)
+	zimFile := strings.TrimSuffix(tarFile, filepath.Ext(tarFile)) + ".zim"
+	zimPath := filepath.Join(filepath.Dir(tarPath), zimFile)
+
+	if zimSum == "" {
+		if _, err := os.Stat(zimPath); err == nil {
+			if zimSum, err = zimChecksum(zimPath); err != nil {
+				log.Printf("Could not compute the checksum of %s: %v", zimFile, err)
+			}
+		}
+	}
+	if zimSum == "" {
+		zimFile = ""
+	}
+
+	err := func() error {
+		tarSum, err := fileChecksum(tarPath, sha256.New())
+		if err != nil {
+			return err
+		}
+
+		reg, err := openRegistry()
+		if err != nil {
+			return err
+		}
+
+		return reg.Add(registry.Record{
+			Zim:         zimFile,
+			ZimChecksum: zimSum,
+			Tar:         tarFile,
+			TarChecksum: tarSum,
+			Reference:   addr.String(),
+			BatchID:     opts.BatchID,
+			Tag:         opts.Tag,
+			Pin:         opts.Pin,
+			Encrypted:   opts.Encrypt,
+		})
+	}()
+	if err != nil {
+		log.Printf("Could not record %s in the registry, keep its reference %s: %v", tarFile, addr, err)
+	}
+}
+
+func printRecords(records []registry.Record) error {
+	if optionJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 2, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Date\tZim\tTar\tReference\tBatch ID\tPin\tEncrypted\t\n")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%t\t\n", r.Timestamp.Local().Format(time.RFC3339), r.Zim, r.Tar, r.Reference, r.BatchID, r.Pin, r.Encrypted)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/beeclient/manifest"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// uploadTarFileResumable uploads the entries of the tar file one by one and
+// builds the manifest of the collection on the client. The uploaded entries
+// are recorded in a checkpoint in the datadir, so a new run only sends the
+// entries that were not uploaded yet. The entries reused from the base mirror,
+// if any, are recorded in the checkpoint as already uploaded.
+func uploadTarFileResumable(ctx context.Context, path string, name string, opts *api.UploadCollectionOptions, base *baseMirror) (swarm.Address, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	cpPath := filepath.Join(optionDataDir, name+checkpointSuffix)
+	cp, err := openCheckpoint(cpPath, info, opts.Encrypt)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	defer cp.Close()
+
+	if ref := cp.Manifest(); ref != "" {
+		log.Printf("Tar file %s was already uploaded according to the checkpoint %s", name, cpPath)
+		return swarm.ParseHexAddress(ref)
+	}
+
+	size := info.Size()
+	if base != nil {
+		for p, ref := range base.reused {
+			if _, ok := cp.Reference(p); ok {
+				continue
+			}
+			if err := cp.Add(p, ref); err != nil {
+				return swarm.Address{}, fmt.Errorf("record %s in checkpoint: %v", p, err)
+			}
+		}
+		size = base.uploadSize
+	}
+
+	if err := checkPostageBatchCapacity(ctx, opts.BatchID, size, opts.Encrypt); err != nil {
+		return swarm.Address{}, err
+	}
+
+	if opts.Tag == 0 {
+		if opts.Tag, err = bee.CreateTag(ctx); err != nil {
+			log.Printf("Not tracking the sync of %s: %v", name, err)
+		}
+	}
+	uploadOpts := api.UploadOptions{
+		Pin:     opts.Pin,
+		Tag:     opts.Tag,
+		BatchID: opts.BatchID,
+		Encrypt: opts.Encrypt,
+	}
+
+	header := fmt.Sprintf("Uploading tar file: %s", name)
+	progressBar := newNetProgressBar(header, int(info.Size()), true)
+	progressBar.Start()
+	err = uploadTarEntries(ctx, f, cp, uploadOpts, func(n int64) {
+		progressBar.Add64(n)
+	})
+	if err == nil {
+		// the headers of the tar file are not uploaded
+		progressBar.SetCurrent(info.Size())
+	}
+	progressBar.Finish()
+	if err != nil {
+		return swarm.Address{}, fmt.Errorf("%v.\nRun the command again with --%s to send the remaining entries", err, optionNameResume)
+	}
+
+	addr, err := storeTarManifest(ctx, cp.Entries(), opts, uploadOpts)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	if err := cp.SetManifest(addr.String()); err != nil {
+		log.Printf("Error recording the manifest in the checkpoint %s: %v", cpPath, err)
+	}
+
+	if opts.Tag != 0 {
+		if err := trackSync(ctx, name, opts.Tag); err != nil {
+			return swarm.Address{}, err
+		}
+	}
+	return addr, nil
+}
+
+// uploadTarEntries uploads the regular files of the tar file missing from the
+// checkpoint, at most optionUploadWorkers at a time. Each file is read directly
+// from its section of the tar file, so an upload can be retried.
+func uploadTarEntries(ctx context.Context, f *os.File, cp *uploadCheckpoint, o api.UploadOptions, progress func(int64)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	workers := optionUploadWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(fmt.Errorf("read tar file: %v", err))
+			break
+		}
+
+		entryPath, ok := tarEntryPath(hdr)
+		if !ok {
+			continue
+		}
+		if _, ok := cp.Reference(entryPath); ok {
+			progress(hdr.Size)
+			continue
+		}
+
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			fail(err)
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(entryPath string, r *io.SectionReader) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			addr, err := bee.UploadBytes(ctx, r, o)
+			if err != nil {
+				fail(fmt.Errorf("upload %s: %v", entryPath, err))
+				return
+			}
+			if err := cp.Add(entryPath, addr.String()); err != nil {
+				fail(fmt.Errorf("record %s in checkpoint: %v", entryPath, err))
+				return
+			}
+			progress(r.Size())
+		}(entryPath, io.NewSectionReader(f, offset, hdr.Size))
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// tarEntryPath returns the path of the entry in the manifest of the tar file.
+// Only regular files are stored, as bee does for tar uploads.
+func tarEntryPath(hdr *tar.Header) (string, bool) {
+	entryPath := filepath.ToSlash(filepath.Clean(hdr.Name))
+	if entryPath == "." || !hdr.FileInfo().Mode().IsRegular() {
+		return "", false
+	}
+	return entryPath, true
+}
+
+// storeTarManifest builds the manifest of the uploaded entries with the same
+// metadata bee sets for a tar upload, so it has the same reference.
+func storeTarManifest(ctx context.Context, entries map[string]string, opts *api.UploadCollectionOptions, o api.UploadOptions) (swarm.Address, error) {
+	if len(entries) == 0 {
+		return swarm.Address{}, fmt.Errorf("no files in tar")
+	}
+
+	m := manifest.New(bee, o)
+	for p, ref := range entries {
+		addr, err := swarm.ParseHexAddress(ref)
+		if err != nil {
+			return swarm.Address{}, fmt.Errorf("invalid reference of %s: %v", p, err)
+		}
+		if err := m.Add(ctx, p, manifest.Entry{Reference: addr}); err != nil {
+			return swarm.Address{}, err
+		}
+	}
+
+	if opts.IndexDocumentHeader != "" {
+		if err := m.SetIndexDocument(opts.IndexDocumentHeader); err != nil {
+			return swarm.Address{}, err
+		}
+	}
+	if opts.ErrorDocumentHeader != "" {
+		m.SetErrorDocument(opts.ErrorDocumentHeader)
+	}
+	return m.Store(ctx)
+}
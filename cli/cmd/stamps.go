@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient"
+	"github.com/r0qs/beezim/internal/beeclient/debugapi"
+
+	"github.com/spf13/cobra"
+)
+
+func newStampsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stamps",
+		Short: "Manage postage stamp batches",
+		Long:  "\nLists, buys, tops up and dilutes the postage stamp batches of the bee node.\nRequires the bee debug api.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := rootCmd.PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			return requireDebugAPI()
+		},
+	}
+	cmd.PersistentFlags().BoolVar(&optionJSON, optionNameJSON, false, "print the batches as JSON")
+	cmd.AddCommand(
+		newStampsListCmd(),
+		newStampsShowCmd(),
+		newStampsBuyCmd(),
+		newStampsTopUpCmd(),
+		newStampsDiluteCmd(),
+	)
+
+	return cmd
+}
+
+// requireDebugAPI returns an error if the bee debug api is not set,
+// e.g. when uploading through the gateway.
+func requireDebugAPI() error {
+	if optionBeeDebugApiUrl == "" {
+		return fmt.Errorf("the bee debug api is required to manage postage batches: provide --%s", optionNameBeeDebugApiUrl)
+	}
+	return nil
+}
+
+// Stamps Subcommands
+func newStampsListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the postage batches of the node",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			batches, err := bee.PostageBatches(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return printPostageBatches(batches)
+		},
+	}
+
+	return cmd
+}
+
+func newStampsShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show <batch-id>",
+		Short: "Show a postage batch",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			batch, err := bee.PostageBatch(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			return printPostageBatches([]debugapi.PostageStampResponse{batch})
+		},
+	}
+
+	return cmd
+}
+
+func newStampsBuyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "buy",
+		Short: "Buy a postage batch",
+		Long:  "\nBuys a postage batch of depth --batch-depth.\nThe amount is given by --batch-amount or estimated from the current price to last for --batch-ttl.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if optionBeeBatchDepth == 0 {
+				return fmt.Errorf("please provide the batch depth with --%s", optionNameBeeBatchDepth)
+			}
+
+			amount := optionBeeBatchAmount
+			if amount == 0 {
+				state, err := bee.ChainState(cmd.Context())
+				if err != nil {
+					return fmt.Errorf("get postage price: %v", err)
+				}
+				amount = beeclient.EstimatePostageBatchAmount(optionBeeBatchTTL, state.CurrentPrice.Int)
+			}
+
+			batchID, err := bee.CreatePostageBatch(cmd.Context(), amount, optionBeeBatchDepth, optionBatchLabel, debugapi.PostageOptions{
+				GasPrice: optionGasPrice,
+			})
+			if err != nil {
+				return err
+			}
+			log.Printf("Bought postage batch %s with depth %d and amount %d", batchID, optionBeeBatchDepth, amount)
+
+			if optionWaitUsable {
+				log.Printf("Waiting until postage batch %s is usable...", batchID)
+				if err := bee.WaitPostageBatchUsable(cmd.Context(), batchID, postageBatchPollInterval); err != nil {
+					return err
+				}
+				log.Printf("Postage batch %s is usable", batchID)
+			}
+
+			fmt.Println(batchID)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&optionBatchLabel, optionNameBatchLabel, "", "label of the postage batch")
+	cmd.Flags().BoolVar(&optionWaitUsable, optionNameWaitUsable, false, "wait until the bought batch is usable")
+
+	return cmd
+}
+
+func newStampsTopUpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "topup <batch-id> <amount>",
+		Short: "Top up a postage batch, extending its TTL",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			amount, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil || amount <= 0 {
+				return fmt.Errorf("invalid amount %q", args[1])
+			}
+
+			batchID, err := bee.TopUpPostageBatch(cmd.Context(), args[0], amount, debugapi.PostageOptions{
+				GasPrice: optionGasPrice,
+			})
+			if err != nil {
+				return err
+			}
+			log.Printf("Postage batch %s topped up by %d per chunk", batchID, amount)
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func newStampsDiluteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dilute <batch-id> <depth>",
+		Short: "Dilute a postage batch, increasing its capacity and reducing its TTL",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			depth, err := strconv.ParseUint(args[1], 10, 8)
+			if err != nil {
+				return fmt.Errorf("invalid depth %q", args[1])
+			}
+
+			batchID, err := bee.DilutePostageBatch(cmd.Context(), args[0], depth, debugapi.PostageOptions{
+				GasPrice: optionGasPrice,
+			})
+			if err != nil {
+				return err
+			}
+			log.Printf("Postage batch %s diluted to depth %d", batchID, depth)
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func printPostageBatches(batches []debugapi.PostageStampResponse) error {
+	if optionJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(batches)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 2, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Batch ID\tLabel\tDepth\tBucket Depth\tUtilization\tUsable\tImmutable\tTTL\t\n")
+	for _, b := range batches {
+		usage := beeclient.PostageBatchUsage(b.Depth, b.BucketDepth, b.Utilization)
+		ttl := time.Duration(b.BatchTTL) * time.Second
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d (%.2f%%)\t%t\t%t\t%v\t\n", b.BatchID, b.Label, b.Depth, b.BucketDepth, b.Utilization, usage*100, b.Usable, b.ImmutableFlag, ttl)
+	}
+	return w.Flush()
+}
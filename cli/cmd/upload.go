@@ -9,14 +9,21 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/r0qs/beezim/internal/beeclient"
 	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/beeclient/debugapi"
 	"github.com/r0qs/beezim/internal/tarball"
 
+	pb "github.com/cheggaaa/pb/v3"
 	"github.com/ethersphere/bee/pkg/swarm"
 	"github.com/spf13/cobra"
 )
 
+// tagPollInterval is how often the sync progress of an upload is checked.
+const tagPollInterval = 2 * time.Second
+
 func newUploadCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "upload",
@@ -29,7 +36,7 @@ func newUploadCmd() *cobra.Command {
 			ctx, cancel := context.WithCancel(cmd.Context())
 			defer cancel()
 
-			addr, err := upload(ctx, optionDataDir, optionTarFile, optionBeeBatchID)
+			addr, err := upload(ctx, optionDataDir, optionTarFile, optionBeeBatchID, "", nil)
 			if err != nil {
 				return err
 			}
@@ -39,6 +46,9 @@ func newUploadCmd() *cobra.Command {
 		},
 	}
 	cmd.Flags().StringVar(&optionTarFile, optionNameTarFile, "", "tar file name")
+	cmd.Flags().BoolVar(&optionEncrypt, optionNameEncrypt, false, "encrypt the uploaded collection, which is only readable with its 64 bytes reference")
+	cmd.Flags().BoolVar(&optionResume, optionNameResume, false, "upload the tar file entry by entry, resuming from the checkpoint of a previous run")
+	cmd.Flags().IntVar(&optionUploadWorkers, optionNameUploadWorkers, 8, "number of entries uploaded concurrently with --resume")
 	// TODO: add upload all option
 	cmd.AddCommand(
 		newUploadAllCmd(),
@@ -57,30 +67,41 @@ func checkTarFileName(tarFile string) error {
 	return nil
 }
 
-func upload(ctx context.Context, dataDir string, tarFile string, batchID string) (swarm.Address, error) {
+// upload uploads the tar file and records it in the registry.
+// The checksum of the zim it was built from is computed if not given.
+// With a base mirror, only the entries changed since it are uploaded.
+func upload(ctx context.Context, dataDir string, tarFile string, batchID string, zimSum string, base *baseMirror) (swarm.Address, error) {
 	tarPath := filepath.Join(dataDir, tarFile)
 	if _, err := os.Stat(tarPath); os.IsNotExist(err) {
 		return swarm.Address{}, fmt.Errorf("tar file %s not found", tarFile)
 	}
-	// TODO: get tag and pin option.
-	// TODO: buy batchID as needed:
-	// estimate the cost of upload the zim and the size of the stamp to do so.
-	// allow users to agree/deny with the cost before proceed with each upload
-	// the stamps will be automatically bought
-	// TODO: keep address for local metadata
-	// TODO: command to buy stamps and check if stamp they are usable
-	// --wait-usable-stamp (keep waiting until bought stamp is ready)
-	addr, err := uploadTarFile(ctx, tarPath, tarFile, api.UploadCollectionOptions{
+	batchID, err := postageBatchFor(ctx, batchID, tarPath, optionEncrypt)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	opts := api.UploadCollectionOptions{
 		MimeType:            api.ContentTypeTar,
 		Tag:                 optionBeeTag,
 		Pin:                 optionBeePin,
 		BatchID:             batchID,
 		IndexDocumentHeader: "index.html",
 		ErrorDocumentHeader: "error.html",
-	})
+		Encrypt:             optionEncrypt,
+	}
+	var addr swarm.Address
+	if optionResume || base != nil {
+		addr, err = uploadTarFileResumable(ctx, tarPath, tarFile, &opts, base)
+	} else {
+		addr, err = uploadTarFile(ctx, tarPath, tarFile, &opts)
+	}
 	if err != nil {
 		return swarm.Address{}, err
 	}
+	recordUpload(tarPath, zimSum, addr, opts)
+	if opts.Encrypt {
+		log.Printf("Collection %s is encrypted: its reference includes the decryption key, share it only with its readers", tarFile)
+	}
 
 	if optionClean {
 		cleanDatadir()
@@ -107,6 +128,7 @@ func newUploadAllCmd() *cobra.Command {
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&optionEncrypt, optionNameEncrypt, false, "encrypt the uploaded collections, which are only readable with their 64 bytes references")
 	cmd.MarkFlagRequired(optionKiwix)
 
 	return cmd
@@ -124,6 +146,7 @@ func uploadAllFrom(ctx context.Context, dataDir string, kiwixMirror string, batc
 		BatchID:             batchID,
 		IndexDocumentHeader: "index.html",
 		ErrorDocumentHeader: "error.html",
+		Encrypt:             optionEncrypt,
 	})
 	if err != nil {
 		return nil, err
@@ -143,10 +166,17 @@ func uploadMatchTar(ctx context.Context, targetDir string, filter func(x string)
 		}
 
 		if !info.IsDir() && filepath.Ext(info.Name()) == ".tar" && filter(info.Name()) {
-			addr, err := uploadTarFile(ctx, path, info.Name(), opts)
+			o := opts
+			o.BatchID, err = postageBatchFor(ctx, opts.BatchID, path, opts.Encrypt)
 			if err != nil {
 				return err
 			}
+
+			addr, err := uploadTarFile(ctx, path, info.Name(), &o)
+			if err != nil {
+				return err
+			}
+			recordUpload(path, "", addr, o)
 			files[info.Name()] = addr
 		}
 		return nil
@@ -160,8 +190,10 @@ func uploadMatchTar(ctx context.Context, targetDir string, filter func(x string)
 	return files, nil
 }
 
+// uploadTarFile uploads the tar file as a collection and tracks the sync of
+// its chunks to the network. If opts has no tag, a new one is created and set.
 // TODO: make generic for any type of collection
-func uploadTarFile(ctx context.Context, path string, name string, opts api.UploadCollectionOptions) (addr swarm.Address, err error) {
+func uploadTarFile(ctx context.Context, path string, name string, opts *api.UploadCollectionOptions) (addr swarm.Address, err error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return swarm.Address{}, err
@@ -173,19 +205,104 @@ func uploadTarFile(ctx context.Context, path string, name string, opts api.Uploa
 		return swarm.Address{}, err
 	}
 
+	if err := checkPostageBatchCapacity(ctx, opts.BatchID, info.Size(), opts.Encrypt); err != nil {
+		return swarm.Address{}, err
+	}
+
+	if opts.Tag == 0 {
+		if opts.Tag, err = bee.CreateTag(ctx); err != nil {
+			log.Printf("Not tracking the sync of %s: %v", name, err)
+		}
+	}
+
 	header := fmt.Sprintf("Uploading tar file: %s", name)
 	progressBar := newNetProgressBar(header, int(info.Size()), false)
 	progressBar.Start()
-	defer progressBar.Finish()
 
-	r, w := io.Pipe()
-	go func() {
-		defer w.Close()
-		tr := tar.NewReader(f)
-		if err = tarball.CopyTar(w, tr); err != nil {
-			log.Fatal(err)
+	body := &tarUpload{f: f, bar: progressBar}
+	if _, err := body.Reopen(); err != nil {
+		progressBar.Finish()
+		return swarm.Address{}, err
+	}
+	defer body.stop()
+
+	addr, err = bee.UploadCollection(ctx, body, info.Size(), *opts)
+	progressBar.Finish()
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	if opts.Tag != 0 {
+		if err := trackSync(ctx, name, opts.Tag); err != nil {
+			return swarm.Address{}, err
 		}
-	}()
+	}
+	return addr, nil
+}
+
+// tarUpload streams the entries of a tar file to bee. It is reopened
+// from the start of the file when the upload is retried.
+type tarUpload struct {
+	f    *os.File
+	bar  *pb.ProgressBar
+	r    *io.PipeReader
+	cur  io.Reader
+	done chan struct{}
+}
+
+func (t *tarUpload) Read(p []byte) (int, error) {
+	return t.cur.Read(p)
+}
+
+// Reopen stops the current copy of the tar file and starts a new one.
+func (t *tarUpload) Reopen() (io.Reader, error) {
+	t.stop()
+	if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	t.r, t.done = r, make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		w.CloseWithError(tarball.CopyTar(w, tar.NewReader(t.f)))
+	}(t.done)
+
+	t.bar.SetCurrent(0)
+	t.cur = t.bar.NewProxyReader(r)
+	return t, nil
+}
 
-	return bee.UploadCollection(ctx, progressBar.NewProxyReader(r), info.Size(), opts)
+// stop stops the current copy and waits for it to return.
+func (t *tarUpload) stop() {
+	if t.r == nil {
+		return
+	}
+	t.r.Close()
+	<-t.done
+	t.r = nil
+}
+
+// trackSync reports how many chunks of the tag are synced to the network.
+// With --wait-synced, it shows the sync progress until all chunks are synced.
+func trackSync(ctx context.Context, name string, uid uint32) error {
+	tag, err := bee.GetTag(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("get tag %d: %v", uid, err)
+	}
+
+	if !optionWaitSynced {
+		log.Printf("%d of %d chunks of %s synced to the network, track them with tag %d", beeclient.TagSynced(tag), tag.Split, name, uid)
+		return nil
+	}
+
+	header := fmt.Sprintf("Syncing chunks of: %s", name)
+	progressBar := newNetProgressBar(header, int(tag.Split), true)
+	progressBar.Start()
+	defer progressBar.Finish()
+
+	return bee.WaitSynced(ctx, uid, tagPollInterval, func(tag debugapi.TagResponse) {
+		progressBar.SetTotal(tag.Split)
+		progressBar.SetCurrent(beeclient.TagSynced(tag))
+	})
 }
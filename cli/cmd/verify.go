@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient/manifest"
+	"github.com/r0qs/beezim/internal/tarball"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/spf13/cobra"
+)
+
+func newVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify that a collection in swarm has the content of its tar file",
+		Long:  "\nDownloads every file of the tar file given by --tar from the collection given by --ref and compares their sizes and hashes.\nThe paths missing from the collection or whose content differs are reported.\nUse --sample to only check some random files of huge collections.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkTarFileName(optionTarFile); err != nil {
+				return err
+			}
+			addr, err := parseCollectionReference(optionReference)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
+			tarPath := filepath.Join(optionDataDir, optionTarFile)
+			mismatches, checked, err := verifyCollection(ctx, tarPath, addr, optionSample, optionParallel)
+			if err != nil {
+				return err
+			}
+			for _, m := range mismatches {
+				fmt.Printf("%s\t%s\n", m.Problem, m.Path)
+			}
+			if len(mismatches) > 0 {
+				return fmt.Errorf("%d of %d files checked do not match the collection %s", len(mismatches), checked, addr)
+			}
+			log.Printf("All %d files checked match the collection %s", checked, addr)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&optionReference, optionNameReference, "", "reference of the collection to verify")
+	cmd.Flags().StringVar(&optionTarFile, optionNameTarFile, "", "tar file the collection was uploaded from")
+	cmd.Flags().IntVar(&optionSample, optionNameSample, 0, "only check the given number of random files")
+	cmd.Flags().IntVar(&optionParallel, optionNameParallel, 8, "maximum number of concurrent downloads")
+	cmd.MarkFlagRequired(optionNameReference)
+	cmd.MarkFlagRequired(optionNameTarFile)
+
+	return cmd
+}
+
+// tarEntry is a regular file of a tar file and its section in it.
+type tarEntry struct {
+	path   string
+	offset int64
+	size   int64
+}
+
+// verifyMismatch is a file of the tar file that does not match the collection.
+type verifyMismatch struct {
+	Path    string
+	Problem string
+}
+
+// verifyCollection compares the files of the tar file with the entries of the
+// collection at the same paths, checking at most parallel files at a time.
+// It returns the mismatching files and the number of files checked.
+func verifyCollection(ctx context.Context, tarPath string, addr swarm.Address, sample int, parallel int) ([]verifyMismatch, int, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	entries, err := listTarEntries(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(entries) == 0 {
+		return nil, 0, fmt.Errorf("no files in tar")
+	}
+	if sample > 0 && sample < len(entries) {
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		rnd.Shuffle(len(entries), func(i, j int) {
+			entries[i], entries[j] = entries[j], entries[i]
+		})
+		entries = entries[:sample]
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		mismatches []verifyMismatch
+		firstErr   error
+	)
+	sem := make(chan struct{}, parallel)
+	m := manifest.NewReader(bee, addr)
+
+	header := fmt.Sprintf("Verifying collection: %s", filepath.Base(tarPath))
+	progressBar := newNetProgressBar(header, len(entries), true)
+	progressBar.Start()
+	for _, e := range entries {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(e tarEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			problem, err := verifyTarEntry(ctx, m, addr, e.path, io.NewSectionReader(f, e.offset, e.size))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				// the first tar read error stops the other lookups
+				firstErr = err
+				cancel()
+			}
+			if problem != "" {
+				mismatches = append(mismatches, verifyMismatch{Path: e.path, Problem: problem})
+			}
+			progressBar.Increment()
+		}(e)
+	}
+	wg.Wait()
+	progressBar.Finish()
+
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Path < mismatches[j].Path
+	})
+	return mismatches, len(entries), nil
+}
+
+// verifyTarEntry compares a file of the tar file with the entry of the
+// collection at its path and returns the problem found, if any. Errors
+// reading the tar file abort the verification, while download errors
+// are reported as problems of the file.
+func verifyTarEntry(ctx context.Context, m *manifest.Reader, addr swarm.Address, p string, r *io.SectionReader) (string, error) {
+	h := tarball.FileHasher()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("read %s from tar: %v", p, err)
+	}
+
+	// bee serves the error document for the paths without entry,
+	// so they are looked up in the manifest first
+	if _, err := m.Lookup(ctx, p); errors.Is(err, manifest.ErrNotFound) {
+		return "missing", nil
+	} else if err != nil {
+		return fmt.Sprintf("lookup failed: %v", err), nil
+	}
+
+	size, hash, err := bee.DownloadManifestFile(ctx, addr, p)
+	if err != nil {
+		return fmt.Sprintf("download failed: %v", err), nil
+	}
+	if size != r.Size() {
+		return fmt.Sprintf("corrupted: size %d instead of %d", size, r.Size()), nil
+	}
+	if !bytes.Equal(hash, h.Sum(nil)) {
+		return "corrupted: hash mismatch", nil
+	}
+	return "", nil
+}
+
+// listTarEntries returns the regular files of the tar file with their sections.
+func listTarEntries(f *os.File) ([]tarEntry, error) {
+	var entries []tarEntry
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar file: %v", err)
+		}
+		entryPath, ok := tarEntryPath(hdr)
+		if !ok {
+			continue
+		}
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, tarEntry{path: entryPath, offset: offset, size: hdr.Size})
+	}
+	return entries, nil
+}
@@ -3,56 +3,78 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
 	"text/tabwriter"
 
+	"github.com/r0qs/beezim/internal/kiwix"
+
 	"github.com/spf13/cobra"
 )
 
-// List of compressed websites currently maintained by Kiwix
-var zims = []string{
-	"gutenberg",
-	"mooc",
-	"other",
-	"phet",
-	"psiram",
-	"stack_exchange",
-	"ted",
-	"videos",
-	"vikidia",
-	"wikibooks",
-	"wikihow",
-	"wikinews",
-	"wikipedia",
-	"wikiquote",
-	"wikisource",
-	"wikiversity",
-	"wikivoyage",
-	"wiktionary",
-	"zimit",
-}
+func newListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Shows the zim files currently distributed by Kiwix",
+		Long:  "\nFetches the Kiwix catalog and lists the available zim files.\nThe values of the Zim and Project columns can be passed as --zim and --kiwix to download and mirror, or the Url column as --url.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter := kiwix.Filter{
+				Language: optionLang,
+				Flavour:  optionFlavour,
+				Name:     optionZimName,
+			}
+			// --kiwix always has a default value, so only filter by it when explicitly set
+			if cmd.Flags().Changed(optionNameKiwix) {
+				filter.Project = optionKiwix
+			}
 
-var listWebCmd = &cobra.Command{
-	Use:   "list",
-	Short: "Shows the list of compressed websites currently maintained by Kiwix",
-	Args:  cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
-		printWebsiteList()
-	},
-}
+			entries, err := kiwix.FetchCatalog(cmd.Context(), optionCatalogURL)
+			if err != nil {
+				return err
+			}
+
+			printZimList(kiwix.FilterEntries(entries, filter))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&optionCatalogURL, optionNameCatalogURL, kiwix.CatalogURL, "url or path of the kiwix OPDS catalog")
+	cmd.Flags().StringVar(&optionLang, optionNameLang, "", "filter zim files by language (e.g. es or spa)")
+	cmd.Flags().StringVar(&optionFlavour, optionNameFlavour, "", "filter zim files by flavour (e.g. mini, nopic or maxi)")
+	cmd.Flags().StringVar(&optionZimName, optionNameZimName, "", "filter zim files whose name contains the given text")
 
-func websitePath(website string) string {
-	return fmt.Sprintf("%s/%s", kiwixZimURL, website)
+	return cmd
 }
 
-func printWebsiteList() {
+func printZimList(entries []kiwix.Entry) {
 	const sep = "======="
 
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].File() < entries[j].File()
+	})
+
 	w := tabwriter.NewWriter(os.Stdout, 2, 8, 2, ' ', 0)
-	fmt.Fprintf(w, "%s Kiwix Zims: %d available compressed websites %s\n", sep, len(zims), sep)
-	fmt.Fprintf(w, "#\tWebsite\tURL\t\n")
-	for i, site := range zims {
-		fmt.Fprintf(w, "%00d\t%s\t%s\t", i+1, site, websitePath(site))
-		fmt.Fprintln(w, "")
+	fmt.Fprintf(w, "%s Kiwix Zims: %d available zim files %s\n", sep, len(entries), sep)
+	fmt.Fprintf(w, "#\tZim\tProject\tLanguage\tFlavour\tDate\tSize\tArticles\tUrl\t\n")
+	for i, e := range entries {
+		date := ""
+		if !e.Date.IsZero() {
+			date = e.Date.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t\n", i+1, e.File(), e.Project(), e.Language, e.Flavour, date, formatSize(e.Size), e.ArticleCount, e.URL)
 	}
 	w.Flush()
 }
+
+// formatSize formats a size in bytes using binary prefixes.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,179 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	zim "github.com/akhenakh/gozim"
+)
+
+// lastCluster reads the entries of the last cluster of a ZIM file. gozim
+// takes the end of the last cluster from past the cluster pointers, so it
+// cannot read the last cluster, which usually holds the metadata: it is read
+// here ending at the checksum of the ZIM. The cluster is decompressed once,
+// on the first read of one of its entries, and kept in memory.
+type lastCluster struct {
+	f            *os.File
+	clusterCount uint32
+	start        int64
+	end          int64
+
+	once sync.Once
+	// blobs are the blobs of the decompressed cluster
+	blobs [][]byte
+	err   error
+}
+
+// openLastCluster opens the ZIM file and reads the position of its last cluster.
+func openLastCluster(zimPath string) (*lastCluster, error) {
+	f, err := os.Open(zimPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cluster count is at offset 28 of the header, the position of
+	// the cluster pointers at offset 48 and of the checksum at offset 72
+	var header [80]byte
+	if _, err := f.ReadAt(header[:], 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading zim header: %v", err)
+	}
+	c := &lastCluster{
+		f:            f,
+		clusterCount: binary.LittleEndian.Uint32(header[28:32]),
+		end:          int64(binary.LittleEndian.Uint64(header[72:80])),
+	}
+	if c.clusterCount == 0 {
+		return c, nil
+	}
+
+	clusterPtrPos := int64(binary.LittleEndian.Uint64(header[48:56]))
+	var ptr [8]byte
+	if _, err := f.ReadAt(ptr[:], clusterPtrPos+int64(c.clusterCount-1)*8); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading zim cluster pointers: %v", err)
+	}
+	c.start = int64(binary.LittleEndian.Uint64(ptr[:]))
+	return c, nil
+}
+
+// Close closes the ZIM file.
+func (c *lastCluster) Close() error {
+	return c.f.Close()
+}
+
+// data reads the data of the article. The articles of the other clusters are
+// read by gozim.
+func (c *lastCluster) data(a *zim.Article) ([]byte, error) {
+	// redirects have no cluster
+	if a.EntryType >= zim.LinkTargetEntry {
+		return a.Data()
+	}
+
+	// mime type (2 bytes), parameter length (1 byte), namespace (1 byte),
+	// revision (4 bytes), cluster number (4 bytes) and blob number (4 bytes)
+	var entry [16]byte
+	if _, err := c.f.ReadAt(entry[:], int64(a.URLPtr)); err != nil {
+		return nil, fmt.Errorf("error reading zim entry: %v", err)
+	}
+	cluster := binary.LittleEndian.Uint32(entry[8:12])
+	blob := binary.LittleEndian.Uint32(entry[12:16])
+	if cluster >= c.clusterCount {
+		return nil, fmt.Errorf("cluster %d out of the %d clusters of the zim", cluster, c.clusterCount)
+	}
+	if cluster+1 < c.clusterCount {
+		return a.Data()
+	}
+
+	c.once.Do(func() {
+		c.blobs, c.err = c.read()
+	})
+	if c.err != nil {
+		return nil, fmt.Errorf("error reading zim cluster %d: %v", cluster, c.err)
+	}
+	if int(blob) >= len(c.blobs) {
+		return nil, fmt.Errorf("blob %d out of the %d blobs of cluster %d", blob, len(c.blobs), cluster)
+	}
+	return c.blobs[blob], nil
+}
+
+// read decompresses the last cluster and splits it into its blobs.
+func (c *lastCluster) read() ([][]byte, error) {
+	if c.end <= c.start {
+		return nil, fmt.Errorf("invalid cluster size")
+	}
+
+	// the first byte of the cluster sets its compression
+	// and if its blob offsets are 8 bytes long
+	var info [1]byte
+	if _, err := c.f.ReadAt(info[:], c.start); err != nil {
+		return nil, err
+	}
+	var r io.Reader = io.NewSectionReader(c.f, c.start+1, c.end-c.start-1)
+	switch info[0] & 0x0f {
+	case 0, 1:
+	case 4:
+		dec, err := zim.NewXZReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		r = dec
+	case 5:
+		dec, err := zim.NewZstdReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		r = dec
+	default:
+		return nil, fmt.Errorf("unknown compression %d", info[0]&0x0f)
+	}
+
+	offsetSize := 4
+	if info[0]&0x10 != 0 {
+		offsetSize = 8
+	}
+	offset := func(b []byte) int64 {
+		if offsetSize == 8 {
+			return int64(binary.LittleEndian.Uint64(b))
+		}
+		return int64(binary.LittleEndian.Uint32(b))
+	}
+
+	// the first offset is the size of the offsets, which are followed by
+	// the blobs up to the last offset
+	first := make([]byte, offsetSize)
+	if _, err := io.ReadFull(r, first); err != nil {
+		return nil, fmt.Errorf("error reading blob offsets: %v", err)
+	}
+	size := offset(first)
+	if size < int64(offsetSize) || size%int64(offsetSize) != 0 {
+		return nil, fmt.Errorf("invalid blob offsets")
+	}
+	rest := make([]byte, size-int64(offsetSize))
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, fmt.Errorf("error reading blob offsets: %v", err)
+	}
+	offsets := []int64{size}
+	for i := 0; i < len(rest); i += offsetSize {
+		o := offset(rest[i : i+offsetSize])
+		if o < offsets[len(offsets)-1] {
+			return nil, fmt.Errorf("invalid blob offsets")
+		}
+		offsets = append(offsets, o)
+	}
+
+	data := make([]byte, offsets[len(offsets)-1]-size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("error reading blobs: %v", err)
+	}
+	blobs := make([][]byte, len(offsets)-1)
+	for i := range blobs {
+		blobs[i] = data[offsets[i]-size : offsets[i+1]-size : offsets[i+1]-size]
+	}
+	return blobs, nil
+}
@@ -0,0 +1,88 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"path"
+)
+
+// Entries that fail to be extracted are written to the exceptions directory,
+// like is done by the zim-tools, instead of being silently dropped.
+// https://github.com/openzim/zim-tools/blob/a26a450110e9ca2ec1b20de8237a3bd382af71f5/src/zimdump.cpp#L214
+const (
+	ExceptionsDir      = "_exceptions"
+	exceptionsManifest = "errors.json"
+)
+
+// Exception describes a ZIM entry that could not be extracted.
+type Exception struct {
+	Path      string `json:"path"`
+	Namespace string `json:"namespace,omitempty"`
+	Error     string `json:"error"`
+}
+
+// Exceptions returns the entries that failed to be extracted so far.
+func (idx *SwarmZimIndexer) Exceptions() []Exception {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return append([]Exception(nil), idx.exceptions...)
+}
+
+// exception records an entry that failed to be extracted and returns the
+// article to be written in its place under the exceptions directory.
+// The content of the article is the error message. The namespace is zero
+// when the entry could not be read.
+func (idx *SwarmZimIndexer) exception(entryPath string, namespace byte, err error) Article {
+	e := Exception{
+		Path:  entryPath,
+		Error: err.Error(),
+	}
+	if namespace != 0 {
+		e.Namespace = string(namespace)
+	}
+	idx.mu.Lock()
+	idx.exceptions = append(idx.exceptions, e)
+	idx.mu.Unlock()
+
+	data := []byte(err.Error())
+	return Article{
+		// the entry path is escaped so all exceptions are kept in a flat directory
+		path:      path.Join(ExceptionsDir, url.PathEscape(entryPath)),
+		namespace: namespace,
+		open: func() (io.Reader, int64, error) {
+			return bytes.NewReader(data), int64(len(data)), nil
+		},
+	}
+}
+
+// exceptionsManifestArticle returns the manifest listing all the exceptions.
+func (idx *SwarmZimIndexer) exceptionsManifestArticle() (Article, error) {
+	data, err := json.MarshalIndent(idx.Exceptions(), "", "  ")
+	if err != nil {
+		return Article{}, err
+	}
+
+	return Article{
+		path: path.Join(ExceptionsDir, exceptionsManifest),
+		open: func() (io.Reader, int64, error) {
+			return bytes.NewReader(data), int64(len(data)), nil
+		},
+	}, nil
+}
+
+// openArticle opens the article content, replacing the article by an
+// exception when its content cannot be read from the ZIM.
+func (idx *SwarmZimIndexer) openArticle(file Article) (Article, io.Reader, int64, error) {
+	r, size, err := file.Open()
+	if err == nil {
+		return file, r, size, nil
+	}
+
+	idx.RemoveEntry(file.path)
+	file = idx.exception(file.path, file.namespace, err)
+	r, size, err = file.Open()
+	return file, r, size, err
+}
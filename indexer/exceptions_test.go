@@ -0,0 +1,106 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/r0qs/beezim/internal/zimfile"
+)
+
+// testZimWithRedirect writes a ZIM with two articles and a redirect,
+// and returns its path.
+func testZimWithRedirect(t *testing.T) string {
+	t.Helper()
+	zimPath := filepath.Join(t.TempDir(), "test.zim")
+	f, err := os.Create(zimPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw, err := zimfile.NewWriter(f, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"Article", "Other_Article"} {
+		if err := zw.Add(zimfile.Entry{Namespace: 'A', URL: url, MimeType: "text/html"}, strings.NewReader("<html></html>"), 13); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.AddRedirect(zimfile.Entry{Namespace: 'A', URL: "Redirect"}, "A/Article"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return zimPath
+}
+
+func TestUnreadableEntryException(t *testing.T) {
+	zimPath := testZimWithRedirect(t)
+
+	// the url pointer of the second entry, at offset 32 of the header,
+	// points past the end of the file
+	f, err := os.OpenFile(zimPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ptr [8]byte
+	if _, err := f.ReadAt(ptr[:], 32); err != nil {
+		t.Fatal(err)
+	}
+	urlPtrPos := int64(binary.LittleEndian.Uint64(ptr[:]))
+	binary.LittleEndian.PutUint64(ptr[:], 1<<40)
+	if _, err := f.WriteAt(ptr[:], urlPtrPos+8); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	idx, err := New(zimPath, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	var paths []string
+	for a := range idx.ParseZIM() {
+		paths = append(paths, a.Path())
+	}
+	want := path.Join(ExceptionsDir, "url-index-1")
+	if strings.Join(paths, " ") != "A/Article "+want+" A/Redirect" {
+		t.Errorf("got entries %v, want the unreadable entry written to %s", paths, want)
+	}
+
+	exceptions := idx.Exceptions()
+	if len(exceptions) != 1 || exceptions[0].Path != "url-index-1" || exceptions[0].Namespace != "" {
+		t.Errorf("got exceptions %+v, want the unreadable entry", exceptions)
+	}
+}
+
+func TestMappedRedirects(t *testing.T) {
+	idx, err := New(testZimWithRedirect(t), Options{RedirectMode: RedirectMap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	var written int
+	for range idx.ParseZIM() {
+		written++
+	}
+	if written != 2 || idx.MappedRedirects() != 1 {
+		t.Errorf("got %d entries written and %d redirects mapped, want 2 and 1", written, idx.MappedRedirects())
+	}
+	if len(idx.Entries())-idx.MappedRedirects() != written {
+		t.Errorf("got %d entries indexed, want the %d written and the mapped redirect", len(idx.Entries()), written)
+	}
+}
@@ -3,10 +3,11 @@ package indexer
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"log"
@@ -14,6 +15,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,56 +35,219 @@ func init() {
 	assetsDir = filepath.Join(filepath.Dir(pwd), "assets")
 }
 
+// Article is a ZIM entry ready to be written to the output.
+// Its content is not kept in memory, instead it is decompressed from the
+// ZIM cluster only when the article is opened by the writer, unless it
+// was prefetched by a worker.
 type Article struct {
-	path  string
-	isDir bool
-	data  []byte
+	path      string
+	namespace byte
+	isDir     bool
+	open      func() (io.Reader, int64, error)
 }
 
 func (a Article) Path() string {
 	return a.path
 }
 
-func (a Article) Data() []byte {
-	return a.Data()
+// Open returns a reader for the article content and its size in bytes.
+func (a Article) Open() (io.Reader, int64, error) {
+	if a.open == nil {
+		return bytes.NewReader(nil), 0, nil
+	}
+	return a.open()
+}
+
+// prefetch reads the article content so it is kept in memory until opened,
+// if it fits in the budget. Otherwise the content is dropped and the article
+// is decompressed again by the writer when it is opened.
+func (a Article) prefetch(budget *prefetchBudget) Article {
+	if a.isDir {
+		return a
+	}
+
+	r, size, err := a.Open()
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(r)
+	}
+	if err == nil && !budget.acquire(int64(len(data))) {
+		return a
+	}
+
+	var release sync.Once
+	a.open = func() (io.Reader, int64, error) {
+		release.Do(func() { budget.release(int64(len(data))) })
+		if err != nil {
+			return nil, 0, err
+		}
+		return bytes.NewReader(data), size, nil
+	}
+	return a
+}
+
+// prefetchBudget bounds the bytes of the articles prefetched by the workers
+// and waiting to be written. It never blocks: the articles that do not fit
+// are left to the writer, since blocking a worker until the writer consumes
+// the articles listed after its own would deadlock the ordered output.
+type prefetchBudget struct {
+	mu        sync.Mutex
+	available int64
+}
+
+func newPrefetchBudget(size int64) *prefetchBudget {
+	return &prefetchBudget{available: size}
+}
+
+func (b *prefetchBudget) acquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > b.available {
+		return false
+	}
+	b.available -= n
+	return true
+}
+
+func (b *prefetchBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.available += n
 }
 
 type IndexMetadata struct {
 	Title    string
 	MimeType string
 	Redirect bool
+	// Namespace and URL locate the entry in the ZIM file,
+	// so it can be rebuilt from the mirror.
+	Namespace string `json:",omitempty"`
+	URL       string `json:",omitempty"`
+	// RedirectTarget is the path of the entry a redirect points to.
+	RedirectTarget string `json:",omitempty"`
+	// MainPage is set on the main page of the ZIM file.
+	MainPage bool `json:",omitempty"`
+	// Hash is the hex SHA3-256 hash of the content written to the tar file,
+	// so the unchanged entries of a new release can be found.
+	Hash string `json:",omitempty"`
 }
 
 type SwarmZimIndexer struct {
-	mu           sync.Mutex
-	ZimPath      string
-	Z            *zim.ZimReader
-	entries      map[string]IndexEntry
-	enableSearch bool
+	mu      sync.Mutex
+	ZimPath string
+	Z       *zim.ZimReader
+	// last reads the last cluster of the ZIM, which gozim cannot read
+	last    *lastCluster
+	entries map[string]IndexEntry
+	// exceptions lists the entries that failed to be extracted
+	exceptions []Exception
+	policy     *NamespacePolicy
+	// newLayout is set when the ZIM uses the new namespace scheme
+	newLayout bool
+	// moved maps the hashes of the urls of the content entries of the new
+	// namespace scheme to their namespace if it is not 'A'
+	moved        map[uint64]byte
+	workers      int
+	prefetch     *prefetchBudget
+	redirectMode RedirectMode
+	// redirects maps the redirect entries to their targets when
+	// they are not written as HTML pages
+	redirects map[string]string
+	// archive is the ZIM file uploaded along the mirror, if any
+	archive *Archive
+}
+
+// Archive describes the ZIM file uploaded to Swarm along the mirror,
+// which is linked from the about page.
+type Archive struct {
+	Name string
+	// Reference is the Swarm reference of the ZIM file uploaded as bytes.
+	Reference string
+	Size      int64
+	// Checksum is the sha256 checksum of the ZIM file.
+	Checksum string
+}
+
+// Options configures how the ZIM is parsed.
+type Options struct {
+	// Policy decides which namespaces are written to the output.
+	// If nil, the default policy without the search indexes is used.
+	Policy *NamespacePolicy
+	// Workers sets how many entries are parsed concurrently.
+	Workers int
+	// PrefetchBytes bounds the bytes of the entries decompressed ahead of
+	// time by the workers, DefaultPrefetchBytes if zero.
+	PrefetchBytes int64
+	// RedirectMode sets how redirect entries are written, HTML pages by default.
+	RedirectMode RedirectMode
 }
 
-// TODO: store root in a local kv db pointing to the metadata in swarm
-// or maybe in a feed and parse the feed on load to collect all root pages and their metadata.
+// DefaultPrefetchBytes is the default bound of the bytes prefetched
+// by the workers.
+const DefaultPrefetchBytes = 64 << 20
 
 type IndexEntry struct {
 	Path     string
 	Metadata IndexMetadata
 }
 
-func New(zimPath string, enableSearch bool) (*SwarmZimIndexer, error) {
+// New creates an indexer for the given ZIM file.
+func New(zimPath string, opts Options) (*SwarmZimIndexer, error) {
+	major, minor, err := readZimVersion(zimPath)
+	if err != nil {
+		return nil, err
+	}
+
 	z, err := zim.NewReader(zimPath, false)
 	if err != nil {
+		return nil, fmt.Errorf("error reading zim version %d.%d: %v", major, minor, err)
+	}
+
+	last, err := openLastCluster(zimPath)
+	if err != nil {
+		z.Close()
 		return nil, err
 	}
 
+	if opts.Policy == nil {
+		opts.Policy = DefaultNamespacePolicy(false)
+	}
+
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+
+	if opts.PrefetchBytes <= 0 {
+		opts.PrefetchBytes = DefaultPrefetchBytes
+	}
+
+	if opts.RedirectMode == "" {
+		opts.RedirectMode = RedirectHTML
+	}
+
 	return &SwarmZimIndexer{
 		ZimPath:      zimPath,
 		Z:            z,
+		last:         last,
 		entries:      make(map[string]IndexEntry),
-		enableSearch: enableSearch,
+		policy:       opts.Policy,
+		workers:      opts.Workers,
+		prefetch:     newPrefetchBudget(opts.PrefetchBytes),
+		newLayout:    isNewLayout(major, minor),
+		redirectMode: opts.RedirectMode,
+		redirects:    make(map[string]string),
 	}, nil
 }
 
+// Close closes the ZIM file.
+func (idx *SwarmZimIndexer) Close() error {
+	if err := idx.last.Close(); err != nil {
+		idx.Z.Close()
+		return err
+	}
+	return idx.Z.Close()
+}
+
 func (idx *SwarmZimIndexer) AddEntry(entryPath string, metadata IndexMetadata) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
@@ -93,14 +258,26 @@ func (idx *SwarmZimIndexer) AddEntry(entryPath string, metadata IndexMetadata) {
 	}
 }
 
+func (idx *SwarmZimIndexer) RemoveEntry(entryPath string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.entries, entryPath)
+}
+
 func (idx *SwarmZimIndexer) Entries() map[string]IndexEntry {
 	return idx.entries
 }
 
+// SetArchive sets the ZIM file linked from the about page.
+func (idx *SwarmZimIndexer) SetArchive(archive *Archive) {
+	idx.archive = archive
+}
+
 func (idx *SwarmZimIndexer) newZIMParserProgressBar() *pb.ProgressBar {
 	header := fmt.Sprintf("Parsing zim file: %s", filepath.Base(idx.ZimPath))
 
-	tmpl := `{{ string . "header" }} | {{counters . }} articles {{ bar . "[" "=" ">" " " "]" }}  {{ percent . }} {{ rtime . "eta %s" }}`
+	tmpl := `{{ string . "header" }} | {{counters . }} articles {{ bar . "[" "=" ">" " " "]" }}  {{ percent . }} {{ speed . "%s articles/s" "? articles/s" }} {{ rtime . "eta %s" }}`
 
 	bar := pb.ProgressBarTemplate(tmpl).New(int(idx.Z.ArticleCount))
 	bar.Set("header", header)
@@ -108,98 +285,217 @@ func (idx *SwarmZimIndexer) newZIMParserProgressBar() *pb.ProgressBar {
 	return bar
 }
 
+// parseJob tracks the parsing of a single ZIM entry by the worker pool.
+type parseJob struct {
+	urlIdx uint32
+	result chan Article
+}
+
+// ParseZIM parses all entries of the ZIM using a pool of workers.
+// Entries are decompressed concurrently, but they are emitted in the same
+// order as they are listed in the ZIM, so the generated tar is deterministic.
+//
+// The memory used by the entries is bounded by the prefetch budget, plus the
+// entry being read by each worker and by the writer. An entry is always
+// read whole, and gozim also caches the last decompressed clusters.
 func (idx *SwarmZimIndexer) ParseZIM() chan Article {
+	if idx.newLayout {
+		idx.moved = idx.movedEntries()
+	}
+
 	zimArticles := make(chan Article)
+	jobs := make(chan parseJob)
+	// pending keeps the jobs in listing order and bounds how many parsed
+	// articles can wait to be written, their bytes are bounded by the
+	// prefetch budget.
+	pending := make(chan parseJob, 2*idx.workers)
+
 	go func() {
-		defer close(zimArticles)
-		progressBar := idx.newZIMParserProgressBar()
-		progressBar.Start()
+		defer close(jobs)
+		defer close(pending)
 
-		// TODO: improve performance for big files
 		idx.Z.ListTitlesPtrIterator(func(i uint32) {
-			a, err := idx.Z.ArticleAtURLIdx(i)
-			if err != nil || a.EntryType == zim.DeletedEntry {
-				return
+			job := parseJob{
+				urlIdx: i,
+				result: make(chan Article, 1),
 			}
+			pending <- job
+			jobs <- job
+		})
+	}()
 
-			// FIXME: for now, all namespaces are considered equal when parsing
-			// https://openzim.org/wiki/ZIM_file_format and
-			// https://openzim.org/wiki/ZIM_file_format_old_namespace
-			//
-			// Namespaces:
-			// '-': Assets (CSS, JS, Favicon)
-			// 'A': Text files (Article Format)
-			// 'I': Media files
-			// 'M': ZIM Metadata
-			// 'X': Search indexes (Xapian DB)
-			switch a.Namespace {
-			case '-', 'A', 'B', 'C', 'I', 'J', 'U', 'W':
-				// TODO: handle categories: https://openzim.org/wiki/Category_Handling
-				// TODO: handle well known entries: https://openzim.org/wiki/Well_known_entries
-				idx.preProcessing(a, zimArticles)
-			case 'M', 'X':
-				//FIXME: handle cases where the zim file was created without xapian
-				// https://github.com/openzim/libzim/blob/11258f9e624d5b288610b7dc6752b62a0af317c2/README.md#compilation
-				if idx.enableSearch {
-					idx.preProcessing(a, zimArticles)
+	for w := 0; w < idx.workers; w++ {
+		go func() {
+			for job := range jobs {
+				if a, ok := idx.parseEntry(job.urlIdx); ok {
+					job.result <- a
 				}
-				// TODO: For now we are ignoring some cases, but we should create "_exceptions/" directory in case of errors extracting the files like is done by the zim-tools.
-				// https://github.com/openzim/zim-tools/blob/a26a450110e9ca2ec1b20de8237a3bd382af71f5/src/zimdump.cpp#L214
-			default:
+				close(job.result)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(zimArticles)
+		progressBar := idx.newZIMParserProgressBar()
+		progressBar.Start()
+
+		for job := range pending {
+			if a, ok := <-job.result; ok {
+				zimArticles <- a
 			}
 			progressBar.Increment()
-		})
+		}
 		progressBar.Finish()
 	}()
 	return zimArticles
 }
 
-func (idx *SwarmZimIndexer) preProcessing(article *zim.Article, zimArticles chan<- Article) {
-	var data []byte
-	var err error
+// parseEntry parses the entry at the given URL index
+// and returns false if the entry must not be written.
+func (idx *SwarmZimIndexer) parseEntry(i uint32) (Article, bool) {
+	a, err := idx.Z.ArticleAtURLIdx(i)
+	if err != nil {
+		// the path of an entry that cannot be read is unknown
+		return idx.exception(fmt.Sprintf("url-index-%d", i), 0, err), true
+	}
+	if a.EntryType == zim.DeletedEntry {
+		return Article{}, false
+	}
+
+	var article Article
+	var ok bool
+
+	// FIXME: for now, all the included namespaces are considered equal when parsing
+	// https://openzim.org/wiki/ZIM_file_format and
+	// https://openzim.org/wiki/ZIM_file_format_old_namespace
+	switch idx.policy.Action(idx.namespace(a)) {
+	case NamespaceInclude, NamespaceRemap:
+		article, ok = idx.preProcessing(a)
+	default:
+	}
+
+	// With a single worker the article is lazily decompressed by the writer,
+	// otherwise the workers decompress it ahead of time.
+	if ok && idx.workers > 1 {
+		article = article.prefetch(idx.prefetch)
+	}
+	return article, ok
+}
+
+// namespace returns the namespace of the article normalized to the old
+// namespace scheme, so the same policy applies to both layouts and
+// the articles of old and new ZIMs share the same URL structure.
+// The content of the new scheme is classified by its MIME type, and
+// redirects take the namespace of their target.
+func (idx *SwarmZimIndexer) namespace(article *zim.Article) byte {
+	if !idx.newLayout || article.Namespace != 'C' {
+		return article.Namespace
+	}
+	if article.EntryType == zim.RedirectEntry {
+		ridx, err := article.RedirectIndex()
+		if err != nil {
+			return 'A'
+		}
+		ra, err := idx.Z.ArticleAtURLIdx(ridx)
+		if err != nil || ra.EntryType == zim.RedirectEntry {
+			return 'A'
+		}
+		return idx.namespace(ra)
+	}
+	return mimeNamespace(article.MimeType())
+}
+
+// entryPath returns the path of the article in the output
+// according to the namespace policy.
+func (idx *SwarmZimIndexer) entryPath(article *zim.Article) string {
+	url := strings.TrimPrefix(article.FullURL(), string(article.Namespace)+"/")
+	return idx.policy.Path(idx.namespace(article), url)
+}
+
+func (idx *SwarmZimIndexer) preProcessing(article *zim.Article) (Article, bool) {
+	var open func() (io.Reader, int64, error)
+
+	namespace := idx.namespace(article)
+	entryPath := idx.entryPath(article)
+	url := strings.TrimPrefix(article.FullURL(), string(article.Namespace)+"/")
+	var redirectTarget string
 
 	if article.EntryType == zim.RedirectEntry {
 		ridx, err := article.RedirectIndex()
 		if err != nil {
-			return
+			return idx.exception(entryPath, namespace, err), true
 		}
 
 		ra, err := idx.Z.ArticleAtURLIdx(ridx)
 		if err != nil {
-			return
+			return idx.exception(entryPath, namespace, fmt.Errorf("redirect target %d: %v", ridx, err)), true
 		}
 
-		buf, err := buildRedirectPage(path.Base(ra.FullURL()))
+		redirectTarget = idx.entryPath(ra)
+
+		// redirects resolved by the router are only indexed, not written
+		if idx.redirectMode == RedirectMap {
+			idx.addRedirect(entryPath, redirectTarget)
+			idx.AddEntry(entryPath, IndexMetadata{
+				Title:          article.Title,
+				Redirect:       true,
+				Namespace:      string(namespace),
+				URL:            url,
+				RedirectTarget: redirectTarget,
+			})
+			return Article{}, false
+		}
+
+		// redirect pages are tiny, so they can be built upfront
+		target, err := filepath.Rel(filepath.Dir(entryPath), redirectTarget)
+		if err != nil {
+			return idx.exception(entryPath, namespace, err), true
+		}
+		buf, err := buildRedirectPage(filepath.ToSlash(target))
 		if err != nil {
 			log.Fatalf("error building redirect page: %v", err)
 		}
-		data = buf.Bytes()
-
+		data := buf.Bytes()
+		open = func() (io.Reader, int64, error) {
+			return bytes.NewReader(data), int64(len(data)), nil
+		}
 	} else {
-		// FIXME:This is critical since it reads the article content to a buffer.
-		// We should instead modify gozim to return a reader and pass it directly to the TarZim.
-		data, err = article.Data()
-		if err != nil {
-			return
+		// gozim has no reader over a cluster: the whole blob is copied out of
+		// the decompressed cluster, so an article is held whole in memory.
+		mimeType := article.MimeType()
+		open = func() (io.Reader, int64, error) {
+			data, err := idx.last.data(article)
+			if err != nil {
+				return nil, 0, err
+			}
+			if (idx.newLayout && article.Namespace == 'C') || (!idx.newLayout && idx.policy.remapped()) {
+				data = idx.relink(data, url, namespace, mimeType)
+			}
+			return bytes.NewReader(data), int64(len(data)), nil
 		}
 	}
 
-	dir, err := filepath.Rel(filepath.Dir(article.FullURL()), article.FullURL())
+	dir, err := filepath.Rel(filepath.Dir(entryPath), entryPath)
 	if err != nil {
-		return
-	}
-
-	zimArticles <- Article{
-		path:  article.FullURL(),
-		data:  data,
-		isDir: dir == ".",
+		return idx.exception(entryPath, namespace, err), true
 	}
 
-	idx.AddEntry(article.FullURL(), IndexMetadata{
-		Title:    article.Title,
-		MimeType: article.MimeType(),
-		Redirect: article.EntryType == zim.RedirectEntry,
+	idx.AddEntry(entryPath, IndexMetadata{
+		Title:          article.Title,
+		MimeType:       article.MimeType(),
+		Redirect:       article.EntryType == zim.RedirectEntry,
+		Namespace:      string(namespace),
+		URL:            url,
+		RedirectTarget: redirectTarget,
 	})
+
+	return Article{
+		path:      entryPath,
+		namespace: namespace,
+		isDir:     dir == ".",
+		open:      open,
+	}, true
 }
 
 func (idx *SwarmZimIndexer) UnZim(outputDir string, files <-chan Article) error {
@@ -210,28 +506,52 @@ func (idx *SwarmZimIndexer) UnZim(outputDir string, files <-chan Article) error
 	}
 
 	for file := range files {
-		filePath := filepath.Join(outputDir, file.path)
-		fileDirPath := filepath.Dir(filePath)
-
-		if _, err := os.Stat(fileDirPath); os.IsNotExist(err) {
-			if err := os.MkdirAll(fileDirPath, 0755); err != nil {
-				return err
-			}
-		}
-
-		f, err := os.Create(filePath)
-		if err != nil {
+		if err := idx.extractFile(outputDir, file); err != nil {
 			return err
 		}
+	}
+
+	if len(idx.Exceptions()) == 0 {
+		return nil
+	}
+
+	manifest, err := idx.exceptionsManifestArticle()
+	if err != nil {
+		return err
+	}
+	return idx.extractFile(outputDir, manifest)
+}
+
+func (idx *SwarmZimIndexer) extractFile(outputDir string, file Article) error {
+	if file.isDir {
+		return os.MkdirAll(filepath.Join(outputDir, file.path), 0755)
+	}
+
+	file, r, _, err := idx.openArticle(file)
+	if err != nil {
+		return err
+	}
 
-		if _, err := f.Write(file.data); err != nil {
+	filePath := filepath.Join(outputDir, file.path)
+	fileDirPath := filepath.Dir(filePath)
+
+	if _, err := os.Stat(fileDirPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(fileDirPath, 0755); err != nil {
 			return err
 		}
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
 
+	if _, err := io.Copy(f, r); err != nil {
 		f.Close()
+		return err
 	}
 
-	return nil
+	return f.Close()
 }
 
 func (idx *SwarmZimIndexer) TarZim(tarFile string, files <-chan Article) error {
@@ -243,28 +563,18 @@ func (idx *SwarmZimIndexer) TarZim(tarFile string, files <-chan Article) error {
 
 	tw := tar.NewWriter(f)
 	for file := range files {
-		hdr := &tar.Header{
-			Name: file.path,
-			Mode: 0644,
-			Size: int64(len(file.data)),
-		}
-
-		if file.isDir {
-			hdr.Typeflag = tar.TypeDir
-		} else {
-			hdr.Typeflag = tar.TypeReg
-		}
-
-		if err := tw.WriteHeader(hdr); err != nil {
+		if err := idx.writeTarFile(tw, file); err != nil {
 			return err
 		}
+	}
 
-		// skip write if it is directory
-		if file.isDir {
-			continue
+	if len(idx.Exceptions()) > 0 {
+		manifest, err := idx.exceptionsManifestArticle()
+		if err != nil {
+			return err
 		}
 
-		if _, err := tw.Write(file.data); err != nil {
+		if err := idx.writeTarFile(tw, manifest); err != nil {
 			return err
 		}
 	}
@@ -275,6 +585,49 @@ func (idx *SwarmZimIndexer) TarZim(tarFile string, files <-chan Article) error {
 	return nil
 }
 
+func (idx *SwarmZimIndexer) writeTarFile(tw *tar.Writer, file Article) error {
+	hdr := &tar.Header{
+		Name: file.path,
+		Mode: 0644,
+	}
+
+	// skip write if it is directory
+	if file.isDir {
+		hdr.Typeflag = tar.TypeDir
+		return tw.WriteHeader(hdr)
+	}
+
+	file, r, size, err := idx.openArticle(file)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = file.path
+	hdr.Typeflag = tar.TypeReg
+	hdr.Size = size
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	h := tarball.FileHasher()
+	if _, err := io.Copy(io.MultiWriter(tw, h), r); err != nil {
+		return err
+	}
+	idx.setEntryHash(file.path, hex.EncodeToString(h.Sum(nil)))
+	return nil
+}
+
+// setEntryHash records the content hash of an indexed entry.
+func (idx *SwarmZimIndexer) setEntryHash(entryPath string, hash string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if e, ok := idx.entries[entryPath]; ok {
+		e.Metadata.Hash = hash
+		idx.entries[entryPath] = e
+	}
+}
+
 func buildRedirectPage(pagePath string) (*bytes.Buffer, error) {
 	tmplData := map[string]interface{}{
 		"Path": pagePath,
@@ -293,22 +646,33 @@ func buildRedirectPage(pagePath string) (*bytes.Buffer, error) {
 }
 
 // MakeRedirectIndexPage creates an redirect index to the main page
-// when it exists in the zim archive.
+// when it exists in the zim archive. Otherwise, an index listing
+// all articles is created instead.
 func (idx *SwarmZimIndexer) MakeRedirectIndexPage(tarFile string) error {
-	log.Printf("Appending redirect index.html to %s", filepath.Base(tarFile))
-
 	mainPage, err := idx.Z.MainPage()
 	if err != nil {
 		return err
 	}
 
-	// TODO: handle the case where there is no main page in the article.
-	// Should we add an index and browse all articles?
 	if mainPage == nil {
-		return errors.New("no index found in the ZIM")
+		log.Printf("No main page found in %s", filepath.Base(idx.ZimPath))
+		tmplData := map[string]interface{}{
+			"File":        filepath.Base(idx.ZimPath),
+			"Count":       strconv.Itoa(int(idx.Z.ArticleCount)),
+			"HasMainPage": false,
+			"Search":      false,
+		}
+		if err := idx.makeArticlesIndexPages(tmplData, tarFile); err != nil {
+			return err
+		}
+
+		// only the styles are needed when the search is disabled
+		return addAssets(tarFile, "css", "js/jquery-3.6.0.min.js", "js/bootstrap.bundle.min.js")
 	}
 
-	buf, err := buildRedirectPage(mainPage.FullURL())
+	log.Printf("Appending redirect index.html to %s", filepath.Base(tarFile))
+
+	buf, err := buildRedirectPage(idx.entryPath(mainPage))
 	if err != nil {
 		return err
 	}
@@ -316,6 +680,87 @@ func (idx *SwarmZimIndexer) MakeRedirectIndexPage(tarFile string) error {
 	return tarball.AppendTarFile(tarFile, tarball.NewBufferFile("index.html", buf))
 }
 
+// articlesPerPage is the number of articles listed in each page
+// of the index generated when the ZIM has no main page.
+const articlesPerPage = 1000
+
+type articleLink struct {
+	Path  string
+	Title string
+}
+
+type articlesPage struct {
+	Number   int
+	Name     string
+	From     string
+	To       string
+	Articles []articleLink
+}
+
+// articlesPages splits all the indexed articles in pages sorted alphabetically by title.
+func (idx *SwarmZimIndexer) articlesPages() []*articlesPage {
+	idx.mu.Lock()
+	articles := make([]articleLink, 0, len(idx.entries))
+	for p, entry := range idx.entries {
+		if entry.Metadata.Redirect || entryCategory(p, entry.Metadata.MimeType) != "Articles" {
+			continue
+		}
+
+		title := entry.Metadata.Title
+		if title == "" {
+			title = path.Base(entry.Path)
+		}
+		articles = append(articles, articleLink{Path: entry.Path, Title: title})
+	}
+	idx.mu.Unlock()
+
+	sort.Slice(articles, func(i, j int) bool {
+		ti, tj := strings.ToLower(articles[i].Title), strings.ToLower(articles[j].Title)
+		if ti == tj {
+			return articles[i].Path < articles[j].Path
+		}
+		return ti < tj
+	})
+
+	pages := make([]*articlesPage, 0, len(articles)/articlesPerPage+1)
+	for start := 0; start < len(articles) || start == 0; start += articlesPerPage {
+		end := start + articlesPerPage
+		if end > len(articles) {
+			end = len(articles)
+		}
+
+		page := &articlesPage{
+			Number:   len(pages) + 1,
+			Name:     "index.html",
+			Articles: articles[start:end],
+		}
+		if page.Number > 1 {
+			page.Name = fmt.Sprintf("articles-%d.html", page.Number)
+		}
+		if len(page.Articles) > 0 {
+			page.From = page.Articles[0].Title
+			page.To = page.Articles[len(page.Articles)-1].Title
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// makeArticlesIndexPages creates an index.html listing all articles when the ZIM
+// has no main page. The list is paginated and the following pages are
+// named "articles-<number>.html".
+func (idx *SwarmZimIndexer) makeArticlesIndexPages(tmplData map[string]interface{}, tarFile string) error {
+	pages := idx.articlesPages()
+	tmplData["Pages"] = pages
+	for _, page := range pages {
+		tmplData["Page"] = page
+		if err := makePage(page.Name, "articles.html", tmplData, tarFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // parseTemplate parses a given template and replace content when requested
 func parseTemplate(contentTmpl string, data interface{}) (*bytes.Buffer, error) {
 	baseTmpl, err := template.ParseGlob(filepath.Join(templatesDir, "page/*.html"))
@@ -369,6 +814,48 @@ type Node struct {
 	Nodes    []*Node `json:"nodes"`
 }
 
+// entryCategory classifies an entry by its namespace.
+// Articles and new-style ZIM content share the same namespace,
+// so they are told apart by their MIME type.
+func entryCategory(entryPath string, mimeType string) string {
+	switch path.Dir(entryPath)[0] {
+	case '-':
+		return "Assets"
+	case 'A':
+		return mimeCategory(mimeType)
+	case 'B':
+		return "Articles Metadata"
+	case 'I', 'J':
+		return "Media"
+	case 'M':
+		return "Metadata"
+	case 'X':
+		return "Indexes"
+	default:
+		return "Others" // TODO: handle categories: U,V,W
+	}
+}
+
+// mimeCategory classifies a content entry by its MIME type.
+func mimeCategory(mimeType string) string {
+	mimeType = strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	switch {
+	case mimeType == "", mimeType == "text/html", mimeType == "text/plain":
+		// redirects have no MIME type and always point to articles
+		return "Articles"
+	case strings.HasPrefix(mimeType, "image/"),
+		strings.HasPrefix(mimeType, "video/"),
+		strings.HasPrefix(mimeType, "audio/"):
+		return "Media"
+	case mimeType == "text/css",
+		strings.HasSuffix(mimeType, "javascript"),
+		strings.Contains(mimeType, "font"):
+		return "Assets"
+	default:
+		return "Others"
+	}
+}
+
 func groupDataByPrefix(idxEntries map[string]IndexEntry) map[string]*Node {
 	m := make(map[string]*Node)
 	for p, entry := range idxEntries {
@@ -379,23 +866,7 @@ func groupDataByPrefix(idxEntries map[string]IndexEntry) map[string]*Node {
 			Redirect: entry.Metadata.Redirect,
 			Icon:     "",
 		}
-		var id string
-		switch path.Dir(p)[0] {
-		case '-':
-			id = "Assets"
-		case 'A', 'C':
-			id = "Articles"
-		case 'B':
-			id = "Articles Metadata"
-		case 'I', 'J':
-			id = "Media"
-		case 'M':
-			id = "Metadata"
-		case 'X':
-			id = "Indexes"
-		default:
-			id = "Others" // TODO: handle categories: U,V,W
-		}
+		id := entryCategory(p, entry.Metadata.MimeType)
 
 		if _, ok := m[id]; !ok {
 			m[id] = &Node{
@@ -409,6 +880,18 @@ func groupDataByPrefix(idxEntries map[string]IndexEntry) map[string]*Node {
 	return m
 }
 
+// markMainPage flags the entry of the main page, so it is
+// known when the ZIM file is rebuilt from files.json.
+func (idx *SwarmZimIndexer) markMainPage(entryPath string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if entry, ok := idx.entries[entryPath]; ok {
+		entry.Metadata.MainPage = true
+		idx.entries[entryPath] = entry
+	}
+}
+
 // MakeIndexSearchPage creates a custom index with the text search tool and
 // embed the current main page in the new index.
 func (idx *SwarmZimIndexer) MakeIndexSearchPage(tarFile string) error {
@@ -419,7 +902,8 @@ func (idx *SwarmZimIndexer) MakeIndexSearchPage(tarFile string) error {
 
 	mainURL := ""
 	if mainPage != nil {
-		mainURL = mainPage.FullURL()
+		mainURL = idx.entryPath(mainPage)
+		idx.markMainPage(mainURL)
 	}
 
 	tmplData := map[string]interface{}{
@@ -428,6 +912,8 @@ func (idx *SwarmZimIndexer) MakeIndexSearchPage(tarFile string) error {
 		"Articles":    groupDataByPrefix(idx.entries),
 		"HasMainPage": (mainURL != ""),
 		"MainURL":     mainURL,
+		"Search":      true,
+		"Archive":     idx.archive,
 	}
 
 	// make about's page using about template
@@ -452,43 +938,77 @@ func (idx *SwarmZimIndexer) MakeIndexSearchPage(tarFile string) error {
 		return err
 	}
 
+	// make index listing all articles if there is no main page to embed
+	if mainURL == "" {
+		return idx.makeArticlesIndexPages(tmplData, tarFile)
+	}
+
 	// make index page using index-search template
 	return makePage("index.html", "index-search.html", tmplData, tarFile)
 }
 
-// MakeErrorPage creates an error page
+// MakeErrorPage creates an error page.
+// When the redirects are kept in a map, the error page also resolves
+// them, since Swarm serves it for all paths not found in the manifest.
 func (idx *SwarmZimIndexer) MakeErrorPage(tarFile string) error {
-	data, err := ioutil.ReadFile(filepath.Join(templatesDir, "error.html"))
+	errorTmpl, err := template.ParseFiles(filepath.Join(templatesDir, "error.html"))
 	if err != nil {
+		return fmt.Errorf("error parsing error template: %v", err)
+	}
+
+	tmplData := map[string]interface{}{
+		"RedirectsDir":   RedirectsDir,
+		"RedirectShards": 0,
+	}
+	if idx.redirectMode == RedirectMap {
+		idx.mu.Lock()
+		tmplData["RedirectShards"] = redirectShards(len(idx.redirects))
+		idx.mu.Unlock()
+	}
+
+	var buf bytes.Buffer
+	if err := errorTmpl.ExecuteTemplate(&buf, "error.html", tmplData); err != nil {
 		return err
 	}
 
-	return tarball.AppendTarFile(tarFile, tarball.NewBytesFile("error.html", data))
+	return tarball.AppendTarFile(tarFile, tarball.NewBufferFile("error.html", &buf))
 }
 
 func AddAssets(tarFile string) error {
+	return addAssets(tarFile, ".")
+}
+
+// addAssets appends the given files or directories, relative to the
+// assets directory, to the tar.
+func addAssets(tarFile string, assets ...string) error {
 	log.Printf("Appending assets to %s", filepath.Base(tarFile))
 
 	baseDir := filepath.Base(assetsDir)
-	return filepath.WalkDir(assetsDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
+	for _, asset := range assets {
+		err := filepath.WalkDir(filepath.Join(assetsDir, asset), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
 
-		if d.IsDir() {
-			return nil
-		}
+			if d.IsDir() {
+				return nil
+			}
 
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			return err
-		}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
 
-		name := filepath.Join(baseDir, strings.TrimPrefix(path, assetsDir))
-		if err = tarball.AppendTarFile(tarFile, tarball.NewBytesFile(name, data)); err != nil {
+			name := filepath.Join(baseDir, strings.TrimPrefix(path, assetsDir))
+			if err = tarball.AppendTarFile(tarFile, tarball.NewBytesFile(name, data)); err != nil {
+				return err
+			}
+
+			return nil
+		})
+		if err != nil {
 			return err
 		}
-
-		return nil
-	})
+	}
+	return nil
 }
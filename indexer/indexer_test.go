@@ -0,0 +1,54 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func testArticle(path string, data []byte, opened *int) Article {
+	return Article{
+		path: path,
+		open: func() (io.Reader, int64, error) {
+			*opened++
+			return bytes.NewReader(data), int64(len(data)), nil
+		},
+	}
+}
+
+func TestPrefetchBudget(t *testing.T) {
+	budget := newPrefetchBudget(10)
+	var opened int
+
+	small := testArticle("A/Small", []byte("123456"), &opened).prefetch(budget)
+	large := testArticle("A/Large", []byte("123456"), &opened).prefetch(budget)
+	if opened != 2 {
+		t.Fatalf("got %d articles read by the workers, want 2", opened)
+	}
+
+	// the second article does not fit in the budget,
+	// so it is read again when it is written
+	for _, a := range []Article{small, large} {
+		r, size, err := a.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		if string(data) != "123456" || size != 6 {
+			t.Errorf("got content %q of size %d for %s", data, size, a.Path())
+		}
+	}
+	if opened != 3 {
+		t.Errorf("got %d reads of the articles, want 3", opened)
+	}
+
+	// the budget is released once the articles are written
+	if !budget.acquire(10) {
+		t.Error("budget is not released by the written articles")
+	}
+}
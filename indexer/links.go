@@ -0,0 +1,128 @@
+package indexer
+
+import (
+	"bytes"
+	"hash/fnv"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The content of a ZIM of the new namespace scheme is in a single namespace,
+// so its articles link to the other entries with relative urls that do not
+// resolve once the entries are moved to the namespaces of the old scheme.
+// The links of the HTML articles and CSS files to the moved entries are
+// rewritten to their new path. The links of a ZIM of the old scheme are
+// rewritten the same way when the policy remaps namespaces to other
+// prefixes. Links built by scripts are not rewritten.
+
+// htmlLink matches the href and src attributes of HTML tags.
+var htmlLink = regexp.MustCompile(`(?i)(\s(?:href|src)\s*=\s*)("[^"]*"|'[^']*')`)
+
+// cssLink matches the url() references of CSS files.
+var cssLink = regexp.MustCompile(`(url\(\s*)("[^"]*"|'[^']*'|[^'")\s]+)`)
+
+// movedEntries returns the namespaces of the content entries of the new
+// scheme which are not moved to 'A', by the hash of their url, so the
+// memory used is bounded by the number of media and assets of the ZIM.
+func (idx *SwarmZimIndexer) movedEntries() map[uint64]byte {
+	log.Printf("Classifying the %d entries of the zim by MIME type", idx.Z.ArticleCount)
+	moved := make(map[uint64]byte)
+	for i := uint32(0); i < idx.Z.ArticleCount; i++ {
+		a, err := idx.Z.ArticleAtURLIdx(i)
+		if err != nil || a.Namespace != 'C' {
+			continue
+		}
+		if ns := idx.namespace(a); ns != 'A' {
+			moved[urlHash(strings.TrimPrefix(a.FullURL(), "C/"))] = ns
+		}
+	}
+	return moved
+}
+
+func urlHash(url string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(url))
+	return h.Sum64()
+}
+
+// relink rewrites the relative links of the content of the entry at the url,
+// moved to the given namespace, to the entries moved to another namespace or
+// prefix. Only HTML and CSS content is rewritten.
+func (idx *SwarmZimIndexer) relink(data []byte, entryURL string, namespace byte, mimeType string) []byte {
+	var links *regexp.Regexp
+	switch strings.TrimSpace(strings.Split(mimeType, ";")[0]) {
+	case "text/html":
+		links = htmlLink
+	case "text/css":
+		links = cssLink
+	default:
+		return data
+	}
+
+	return links.ReplaceAllFunc(data, func(m []byte) []byte {
+		sub := links.FindSubmatch(m)
+		value := sub[2]
+		quote := ""
+		if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+			quote = string(value[0])
+			value = value[1 : len(value)-1]
+		}
+		link, ok := idx.relinkURL(string(value), entryURL, namespace)
+		if !ok {
+			return m
+		}
+		return bytes.Join([][]byte{sub[1], []byte(quote + link + quote)}, nil)
+	})
+}
+
+// relinkURL returns the link to the new path of the entry linked by ref from
+// the entry at the url, or false if the link is unchanged.
+func (idx *SwarmZimIndexer) relinkURL(ref string, entryURL string, namespace byte) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+
+	targetNamespace, target, ok := idx.linkTarget(u.Path, entryURL, namespace)
+	if !ok || targetNamespace == namespace {
+		return "", false
+	}
+
+	from := idx.policy.Path(namespace, entryURL)
+	to := idx.policy.Path(targetNamespace, target)
+	rel, err := filepath.Rel(filepath.Dir(from), to)
+	if err != nil {
+		return "", false
+	}
+	u.Path = filepath.ToSlash(rel)
+	u.RawPath = ""
+	return u.String(), true
+}
+
+// linkTarget returns the namespace and url of the entry linked by the
+// relative path from the entry at the url, or false if the path leaves the
+// ZIM. The entries of the new scheme link to each other within 'C', while
+// the ones of the old scheme link across namespaces, as in "../I/logo.png".
+func (idx *SwarmZimIndexer) linkTarget(p string, entryURL string, namespace byte) (byte, string, bool) {
+	if idx.newLayout {
+		target := path.Join(path.Dir(entryURL), p)
+		if target == ".." || strings.HasPrefix(target, "../") {
+			return 0, "", false
+		}
+		targetNamespace, ok := idx.moved[urlHash(target)]
+		if !ok {
+			targetNamespace = 'A'
+		}
+		return targetNamespace, target, true
+	}
+
+	target := path.Join(string(namespace), path.Dir(entryURL), p)
+	if strings.IndexByte(target, '/') != 1 {
+		return 0, "", false
+	}
+	return target[0], target[2:], true
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/r0qs/beezim/internal/zimfile"
+)
+
+const newLayoutArticle = `<html><head><link rel="stylesheet" href="_mw_/style.css"></head>
+<body><img src='_assets_/logo.png'><a href="Other_Article#History">other</a>
+<a href="Logo_Redirect">logo</a><a href="https://example.org/_assets_/logo.png">external</a></body></html>`
+
+const newLayoutStyle = `body { background: url("../_assets_/logo.png"); } h1 { background: url(../_assets_/logo.png) }`
+
+// newLayoutZim writes a ZIM with the content in the 'C' namespace, as in the
+// new namespace scheme, and returns its path. The writer and gozim only
+// handle the version 5.0 of the format, so the file keeps this version.
+func newLayoutZim(t *testing.T) string {
+	t.Helper()
+	zimPath := filepath.Join(t.TempDir(), "new.zim")
+	f, err := os.Create(zimPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw, err := zimfile.NewWriter(f, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct {
+		url, mimeType, content string
+	}{
+		{"Article", "text/html", newLayoutArticle},
+		{"Other_Article", "text/html", "<html></html>"},
+		{"_mw_/style.css", "text/css", newLayoutStyle},
+		{"_assets_/logo.png", "image/png", "png"},
+		{"data.json", "application/json", "{}"},
+	} {
+		err := zw.Add(zimfile.Entry{Namespace: 'C', URL: e.url, MimeType: e.mimeType}, strings.NewReader(e.content), int64(len(e.content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.AddRedirect(zimfile.Entry{Namespace: 'C', URL: "Logo_Redirect"}, "C/_assets_/logo.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return zimPath
+}
+
+// parseNewLayoutZim parses the ZIM as one of the new namespace scheme
+// and returns the content of its entries by path.
+func parseNewLayoutZim(t *testing.T, zimPath string, policy *NamespacePolicy) map[string]string {
+	t.Helper()
+	return parseZimFiles(t, zimPath, policy, true)
+}
+
+// parseZimFiles parses the ZIM and returns the content of its entries by path.
+func parseZimFiles(t *testing.T, zimPath string, policy *NamespacePolicy, newLayout bool) map[string]string {
+	t.Helper()
+	idx, err := New(zimPath, Options{Policy: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	idx.newLayout = newLayout
+
+	files := make(map[string]string)
+	for a := range idx.ParseZIM() {
+		r, _, err := a.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[a.Path()] = string(data)
+	}
+	return files
+}
+
+func TestNewLayoutNamespaces(t *testing.T) {
+	files := parseNewLayoutZim(t, newLayoutZim(t), nil)
+
+	for _, p := range []string{"A/Article", "A/Other_Article", "-/_mw_/style.css", "I/_assets_/logo.png", "I/Logo_Redirect", "J/data.json"} {
+		if _, ok := files[p]; !ok {
+			t.Errorf("entry %s not parsed", p)
+		}
+	}
+	if len(files) != 6 {
+		t.Errorf("got entries %v", files)
+	}
+
+	policy := DefaultNamespacePolicy(false)
+	policy.Skip('I')
+	files = parseNewLayoutZim(t, newLayoutZim(t), policy)
+	if _, ok := files["I/_assets_/logo.png"]; ok {
+		t.Error("media entry parsed with its namespace skipped")
+	}
+	if _, ok := files["A/Article"]; !ok {
+		t.Error("article not parsed with the media skipped")
+	}
+}
+
+var testLink = regexp.MustCompile(`(?:href|src)=["']([^"']*)["']|url[=(]["']?([^"')]*)["']?\)?`)
+
+// checkLinks checks that the links of the entry at the path resolve to
+// the given entries, or are the given external urls.
+func checkLinks(t *testing.T, files map[string]string, p string, links []string) {
+	t.Helper()
+	var got []string
+	for _, m := range testLink.FindAllStringSubmatch(files[p], -1) {
+		link := m[1] + m[2]
+		if strings.Contains(link, "://") {
+			got = append(got, link)
+			continue
+		}
+		target := path.Join(path.Dir(p), strings.SplitN(link, "#", 2)[0])
+		if _, ok := files[target]; !ok {
+			t.Errorf("link %s of %s resolves to the missing %s", link, p, target)
+		}
+		got = append(got, target)
+	}
+	if strings.Join(got, " ") != strings.Join(links, " ") {
+		t.Errorf("got links %v of %s, want %v", got, p, links)
+	}
+}
+
+func TestNewLayoutLinksResolve(t *testing.T) {
+	files := parseNewLayoutZim(t, newLayoutZim(t), nil)
+
+	checkLinks(t, files, "A/Article", []string{"-/_mw_/style.css", "I/_assets_/logo.png", "A/Other_Article", "I/Logo_Redirect", "https://example.org/_assets_/logo.png"})
+	checkLinks(t, files, "-/_mw_/style.css", []string{"I/_assets_/logo.png", "I/_assets_/logo.png"})
+	// the redirect page refreshes to its target in another directory
+	checkLinks(t, files, "I/Logo_Redirect", []string{"I/_assets_/logo.png"})
+
+	if !strings.Contains(files["A/Article"], `href="Other_Article#History"`) {
+		t.Errorf("link between articles changed: %s", files["A/Article"])
+	}
+}
+
+const oldLayoutArticle = `<html><head><link rel="stylesheet" href="../-/s/style.css"></head>
+<body><img src="../I/m/logo.png"><a href="Other_Article">other</a></body></html>`
+
+func TestRemappedNamespaceLinksResolve(t *testing.T) {
+	zimPath := filepath.Join(t.TempDir(), "old.zim")
+	f, err := os.Create(zimPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw, err := zimfile.NewWriter(f, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []struct {
+		namespace              byte
+		url, mimeType, content string
+	}{
+		{'A', "Article", "text/html", oldLayoutArticle},
+		{'A', "Other_Article", "text/html", "<html></html>"},
+		{'-', "s/style.css", "text/css", `body { background: url("../../I/m/logo.png"); }`},
+		{'I', "m/logo.png", "image/png", "png"},
+	} {
+		err := zw.Add(zimfile.Entry{Namespace: e.namespace, URL: e.url, MimeType: e.mimeType}, strings.NewReader(e.content), int64(len(e.content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.AddRedirect(zimfile.Entry{Namespace: 'A', URL: "Logo"}, "I/m/logo.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := DefaultNamespacePolicy(false)
+	policy.Remap('I', "media")
+	policy.Remap('-', "")
+	files := parseZimFiles(t, zimPath, policy, false)
+
+	for _, p := range []string{"A/Article", "A/Other_Article", "A/Logo", "s/style.css", "media/m/logo.png"} {
+		if _, ok := files[p]; !ok {
+			t.Errorf("entry %s not parsed", p)
+		}
+	}
+	checkLinks(t, files, "A/Article", []string{"s/style.css", "media/m/logo.png", "A/Other_Article"})
+	checkLinks(t, files, "s/style.css", []string{"media/m/logo.png"})
+	checkLinks(t, files, "A/Logo", []string{"media/m/logo.png"})
+}
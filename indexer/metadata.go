@@ -0,0 +1,83 @@
+package indexer
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Metadata describes a ZIM file, as stored in its M namespace.
+// See: https://wiki.openzim.org/wiki/Metadata
+type Metadata struct {
+	Name         string `json:"name,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Language     string `json:"language,omitempty"`
+	Creator      string `json:"creator,omitempty"`
+	Publisher    string `json:"publisher,omitempty"`
+	Date         string `json:"date,omitempty"`
+	Flavour      string `json:"flavour,omitempty"`
+	ArticleCount uint64 `json:"articleCount"`
+	MediaCount   uint64 `json:"mediaCount"`
+}
+
+// Metadata reads the metadata of the ZIM. Missing entries are left empty
+// and the article count falls back to the number of entries of the ZIM.
+func (idx *SwarmZimIndexer) Metadata() Metadata {
+	m := Metadata{
+		Name:        idx.metadataValue("Name"),
+		Title:       idx.metadataValue("Title"),
+		Description: idx.metadataValue("Description"),
+		Language:    idx.metadataValue("Language"),
+		Creator:     idx.metadataValue("Creator"),
+		Publisher:   idx.metadataValue("Publisher"),
+		Date:        idx.metadataValue("Date"),
+		Flavour:     idx.metadataValue("Flavour"),
+	}
+
+	m.ArticleCount, m.MediaCount = parseCounter(idx.metadataValue("Counter"))
+	if m.ArticleCount == 0 {
+		m.ArticleCount = uint64(idx.Z.ArticleCount)
+	}
+	return m
+}
+
+// metadataValue returns the value of a metadata entry, or an empty string
+// if the entry does not exist or cannot be read.
+func (idx *SwarmZimIndexer) metadataValue(key string) string {
+	a, err := idx.Z.GetPageNoIndex("M/" + key)
+	if err != nil {
+		return ""
+	}
+
+	data, err := idx.last.data(a)
+	if err != nil {
+		log.Printf("Skipping zim metadata %s: %v", key, err)
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// parseCounter parses the Counter metadata, a list of mime types and the number
+// of entries of each (e.g. "text/html=100;image/png=20"), into the number of
+// articles and media files.
+func parseCounter(counter string) (articles uint64, media uint64) {
+	for _, item := range strings.Split(counter, ";") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(kv[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch mimeCategory(kv[0]) {
+		case "Articles":
+			articles += n
+		case "Media":
+			media += n
+		}
+	}
+	return articles, media
+}
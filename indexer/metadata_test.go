@@ -0,0 +1,156 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	zim "github.com/akhenakh/gozim"
+)
+
+// testZim is a ZIM of Wikibooks written by zimwriterfs, with compressed and
+// uncompressed clusters. Its metadata is stored in its last cluster.
+var testZim = filepath.Join("testdata", "test.zim")
+
+func TestMetadataOfLastCluster(t *testing.T) {
+	idx, err := New(testZim, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	want := Metadata{
+		Title:        "Wikibooks",
+		Description:  "Fram Wikibooks",
+		Language:     "ang",
+		Creator:      "Wikibooks",
+		Publisher:    "Kiwix",
+		Date:         "2014-11-25",
+		ArticleCount: 185,
+		MediaCount:   40,
+	}
+	if got := idx.Metadata(); got != want {
+		t.Errorf("got metadata %+v, want %+v", got, want)
+	}
+}
+
+func TestReadArticleData(t *testing.T) {
+	idx, err := New(testZim, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	// the metadata is stored in the last cluster, compressed with xz,
+	// and the articles in a cluster compressed with xz read by gozim
+	metadata := map[string]string{
+		"M/Counter":     "application/javascript=3;image/gif=4;image/png=24;image/svg+xml=12;text/css=1;text/html=177;text/plain=8;",
+		"M/Creator":     "Wikibooks",
+		"M/Date":        "2014-11-25",
+		"M/Description": "Fram Wikibooks",
+		"M/Language":    "ang",
+		"M/Publisher":   "Kiwix",
+		"M/Title":       "Wikibooks",
+	}
+	var articles int
+	for a := range idx.Z.ListArticles() {
+		if a.EntryType >= zim.LinkTargetEntry {
+			continue
+		}
+		url := a.FullURL()
+		got, err := idx.last.data(a)
+		if err != nil {
+			t.Fatalf("read %s: %v", url, err)
+		}
+
+		if a.Namespace == 'M' {
+			if want := metadata[url]; string(got) != want {
+				t.Errorf("got %q for %s, want %q", got, url, want)
+			}
+			delete(metadata, url)
+			continue
+		}
+
+		want, err := a.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got %d bytes for %s, want %d", len(got), url, len(want))
+		}
+		if a.Namespace == 'A' {
+			articles++
+		}
+	}
+	if len(metadata) > 0 {
+		t.Errorf("metadata %v not read", metadata)
+	}
+	if articles == 0 {
+		t.Error("no article read")
+	}
+}
+
+func TestReadLastClusterOnce(t *testing.T) {
+	idx, err := New(testZim, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	var blobs [][]byte
+	for _, url := range []string{"M/Title", "M/Date", "M/Title"} {
+		a, err := idx.Z.GetPageNoIndex(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := idx.last.data(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, data)
+	}
+	if &blobs[0][0] != &blobs[2][0] {
+		t.Error("last cluster decompressed twice")
+	}
+	if len(idx.last.blobs) != 7 {
+		t.Errorf("got %d blobs in the last cluster, want 7", len(idx.last.blobs))
+	}
+}
+
+func TestParseLastCluster(t *testing.T) {
+	idx, err := New(testZim, Options{Policy: DefaultNamespacePolicy(true), Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	var found bool
+	for a := range idx.ParseZIM() {
+		r, _, err := a.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", a.Path(), err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Path() == "M/Title" {
+			found = true
+			if string(data) != "Wikibooks" {
+				t.Errorf("got title %q, want %q", data, "Wikibooks")
+			}
+		}
+	}
+	if !found {
+		t.Error("metadata of the last cluster not parsed")
+	}
+	if len(idx.Exceptions()) > 0 {
+		t.Errorf("got exceptions %v", idx.Exceptions())
+	}
+}
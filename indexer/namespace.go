@@ -0,0 +1,185 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// NamespaceAction defines what the indexer does with the entries of a namespace.
+type NamespaceAction int
+
+const (
+	// NamespaceSkip drops the entries of the namespace.
+	NamespaceSkip NamespaceAction = iota
+	// NamespaceInclude keeps the entries under their original namespace.
+	NamespaceInclude
+	// NamespaceRemap keeps the entries but moves them to another path prefix.
+	NamespaceRemap
+)
+
+// NamespacePolicy decides per namespace whether the ZIM entries should be
+// included, skipped or remapped in the output.
+// Namespaces without an explicit rule are skipped.
+// See: https://openzim.org/wiki/ZIM_file_format#Namespaces
+type NamespacePolicy struct {
+	actions map[byte]NamespaceAction
+	remaps  map[byte]string
+}
+
+// NewNamespacePolicy returns an empty policy that skips all namespaces.
+func NewNamespacePolicy() *NamespacePolicy {
+	return &NamespacePolicy{
+		actions: make(map[byte]NamespaceAction),
+		remaps:  make(map[byte]string),
+	}
+}
+
+// DefaultNamespacePolicy returns the policy used when none is given.
+// Content namespaces are always included, while the metadata 'M' and the
+// search indexes 'X' are only included when the search is enabled.
+func DefaultNamespacePolicy(enableSearch bool) *NamespacePolicy {
+	p := NewNamespacePolicy()
+	// '-': Assets (CSS, JS, Favicon)
+	// 'A': Text files (Article Format)
+	// 'I': Media files
+	// TODO: handle categories: https://openzim.org/wiki/Category_Handling
+	// TODO: handle well known entries: https://openzim.org/wiki/Well_known_entries
+	p.Include('-', 'A', 'B', 'C', 'I', 'J', 'U', 'W')
+
+	// 'M': ZIM Metadata
+	// 'X': Search indexes (Xapian DB)
+	//FIXME: handle cases where the zim file was created without xapian
+	// https://github.com/openzim/libzim/blob/11258f9e624d5b288610b7dc6752b62a0af317c2/README.md#compilation
+	if enableSearch {
+		p.Include('M', 'X')
+	}
+	return p
+}
+
+// Include keeps the entries of the given namespaces.
+func (p *NamespacePolicy) Include(namespaces ...byte) {
+	for _, ns := range namespaces {
+		p.actions[ns] = NamespaceInclude
+		delete(p.remaps, ns)
+	}
+}
+
+// Skip drops the entries of the given namespaces.
+func (p *NamespacePolicy) Skip(namespaces ...byte) {
+	for _, ns := range namespaces {
+		p.actions[ns] = NamespaceSkip
+		delete(p.remaps, ns)
+	}
+}
+
+// Remap keeps the entries of the namespace under the given path prefix.
+func (p *NamespacePolicy) Remap(namespace byte, prefix string) {
+	p.actions[namespace] = NamespaceRemap
+	p.remaps[namespace] = strings.Trim(prefix, "/")
+}
+
+// Action returns the action for the given namespace.
+func (p *NamespacePolicy) Action(namespace byte) NamespaceAction {
+	if action, ok := p.actions[namespace]; ok {
+		return action
+	}
+	return NamespaceSkip
+}
+
+// remapped reports whether the entries of a namespace are moved to another prefix.
+func (p *NamespacePolicy) remapped() bool {
+	return len(p.remaps) > 0
+}
+
+// Path returns the output path of an entry url of the given namespace.
+func (p *NamespacePolicy) Path(namespace byte, url string) string {
+	if p.Action(namespace) == NamespaceRemap {
+		if prefix := p.remaps[namespace]; prefix != "" {
+			return prefix + "/" + url
+		}
+		return url
+	}
+	return string(namespace) + "/" + url
+}
+
+// ParseNamespaces parses a list of namespaces given as single characters.
+func ParseNamespaces(names []string) ([]byte, error) {
+	namespaces := make([]byte, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) != 1 {
+			return nil, fmt.Errorf("invalid namespace %q: namespaces must be a single character", name)
+		}
+		namespaces = append(namespaces, name[0])
+	}
+	return namespaces, nil
+}
+
+// ParseNamespaceRemaps parses a list of remaps given as namespace=prefix,
+// as in "I=media".
+func ParseNamespaceRemaps(remaps []string) (map[byte]string, error) {
+	prefixes := make(map[byte]string, len(remaps))
+	for _, remap := range remaps {
+		parts := strings.SplitN(remap, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid namespace remap %q: remaps must be given as namespace=prefix", remap)
+		}
+		namespaces, err := ParseNamespaces(parts[:1])
+		if err != nil {
+			return nil, err
+		}
+		prefixes[namespaces[0]] = strings.TrimSpace(parts[1])
+	}
+	return prefixes, nil
+}
+
+// ZIM files created with the new namespace scheme (version 6.1 onwards)
+// store all the content under the 'C' namespace, use 'W' for well-known
+// entries and 'X' for indexes. The content is moved to the namespaces of
+// the old scheme by its MIME type: 'A' for articles, 'I' for media, '-' for
+// assets and 'J' for other files.
+// See: https://openzim.org/wiki/ZIM_file_format#Namespaces
+const (
+	newLayoutMajorVersion = 6
+	newLayoutMinorVersion = 1
+)
+
+// mimeNamespace returns the namespace of the old scheme of a content entry
+// of the new scheme, given by its MIME type.
+func mimeNamespace(mimeType string) byte {
+	switch mimeCategory(mimeType) {
+	case "Articles":
+		return 'A'
+	case "Media":
+		return 'I'
+	case "Assets":
+		return '-'
+	default:
+		return 'J'
+	}
+}
+
+// readZimVersion reads the major and minor version from the ZIM header.
+func readZimVersion(zimPath string) (major, minor uint16, err error) {
+	f, err := os.Open(zimPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	// magic number (4 bytes), major version (2 bytes) and minor version (2 bytes)
+	var header [8]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return 0, 0, fmt.Errorf("error reading zim header: %v", err)
+	}
+	return binary.LittleEndian.Uint16(header[4:6]), binary.LittleEndian.Uint16(header[6:8]), nil
+}
+
+// isNewLayout reports whether a ZIM version uses the new namespace scheme.
+func isNewLayout(major, minor uint16) bool {
+	return major > newLayoutMajorVersion ||
+		(major == newLayoutMajorVersion && minor >= newLayoutMinorVersion)
+}
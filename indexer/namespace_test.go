@@ -0,0 +1,58 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNamespaceRemaps(t *testing.T) {
+	remaps, err := ParseNamespaceRemaps([]string{"I=media", " - = assets/ ", "J="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[byte]string{'I': "media", '-': "assets/", 'J': ""}
+	if !reflect.DeepEqual(remaps, want) {
+		t.Errorf("got remaps %v, want %v", remaps, want)
+	}
+
+	for _, remap := range []string{"I", "IM=media", "=media"} {
+		if _, err := ParseNamespaceRemaps([]string{remap}); err == nil {
+			t.Errorf("got no error parsing %q", remap)
+		}
+	}
+}
+
+func TestNamespacePolicyPath(t *testing.T) {
+	p := DefaultNamespacePolicy(false)
+	p.Remap('I', "/media/")
+	p.Remap('-', "")
+	p.Skip('J')
+	p.Remap('M', "meta")
+	p.Skip('M')
+
+	for _, tc := range []struct {
+		namespace byte
+		action    NamespaceAction
+		path      string
+	}{
+		{'A', NamespaceInclude, "A/Main_Page"},
+		{'I', NamespaceRemap, "media/Main_Page"},
+		{'-', NamespaceRemap, "Main_Page"},
+		{'J', NamespaceSkip, "J/Main_Page"},
+		// the last rule of a namespace wins
+		{'M', NamespaceSkip, "M/Main_Page"},
+		{'X', NamespaceSkip, "X/Main_Page"},
+	} {
+		if action := p.Action(tc.namespace); action != tc.action {
+			t.Errorf("got action %d for %c, want %d", action, tc.namespace, tc.action)
+		}
+		if got := p.Path(tc.namespace, "Main_Page"); got != tc.path {
+			t.Errorf("got path %s for %c, want %s", got, tc.namespace, tc.path)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"path"
+	"path/filepath"
+
+	"github.com/r0qs/beezim/internal/tarball"
+)
+
+// RedirectMode defines how the ZIM redirect entries are written to the output.
+type RedirectMode string
+
+const (
+	// RedirectHTML writes a small HTML page with a meta refresh for each redirect.
+	// It works with any gateway but adds one file per redirect to the mirror.
+	RedirectHTML RedirectMode = "html"
+	// RedirectMap records the redirects in a JSON map split in shards, resolved
+	// by a script in the error page, so redirects cost no content chunks.
+	RedirectMap RedirectMode = "map"
+)
+
+// RedirectsMapFile is the name of the single redirects map of the mirrors
+// parsed before the map was split in shards.
+const RedirectsMapFile = "redirects.json"
+
+// RedirectsDir is the directory of the shards of the redirects map. A redirect
+// is in the shard given by RedirectShard, so the error page only downloads
+// the shard of the missing path. The number of shards is kept in the
+// RedirectsIndexFile of the directory.
+const RedirectsDir = "redirects"
+
+// RedirectsIndexFile is the name of the file holding the number of shards
+// of the redirects map in the RedirectsDir.
+const RedirectsIndexFile = "index.json"
+
+// redirectsPerShard is the number of redirects a shard holds on average,
+// which keeps the shards around a hundred kilobytes.
+const redirectsPerShard = 1000
+
+// RedirectsIndex is the content of the RedirectsIndexFile.
+type RedirectsIndex struct {
+	Shards int `json:"shards"`
+}
+
+// redirectShards returns the number of shards of a map of n redirects,
+// a power of two.
+func redirectShards(n int) int {
+	shards := 1
+	for shards*redirectsPerShard < n {
+		shards *= 2
+	}
+	return shards
+}
+
+// RedirectShard returns the shard of the redirect from the entry path, by
+// the 32 bits FNV-1a hash of the path, which the error page computes too.
+func RedirectShard(entryPath string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(entryPath))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// RedirectShardPath returns the path of a shard of the redirects map.
+func RedirectShardPath(shard int) string {
+	return fmt.Sprintf("%s/%x.json", RedirectsDir, shard)
+}
+
+// ParseRedirectMode parses the name of a redirect mode.
+func ParseRedirectMode(mode string) (RedirectMode, error) {
+	switch RedirectMode(mode) {
+	case "", RedirectHTML:
+		return RedirectHTML, nil
+	case RedirectMap:
+		return RedirectMap, nil
+	default:
+		return "", fmt.Errorf("invalid redirect mode %q: must be %q or %q", mode, RedirectHTML, RedirectMap)
+	}
+}
+
+// addRedirect records a redirect from an entry path to its target path.
+func (idx *SwarmZimIndexer) addRedirect(from string, to string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.redirects[from] = to
+}
+
+// MappedRedirects returns the number of redirects of the redirects map,
+// which are indexed but not written as entries.
+func (idx *SwarmZimIndexer) MappedRedirects() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return len(idx.redirects)
+}
+
+// MakeRedirectsMap appends the shards of the redirects map to the tar when
+// the redirects are resolved by the error page router.
+func (idx *SwarmZimIndexer) MakeRedirectsMap(tarFile string) error {
+	if idx.redirectMode != RedirectMap {
+		return nil
+	}
+
+	idx.mu.Lock()
+	shards := make([]map[string]string, redirectShards(len(idx.redirects)))
+	for i := range shards {
+		shards[i] = make(map[string]string)
+	}
+	for from, to := range idx.redirects {
+		shards[RedirectShard(from, len(shards))][from] = to
+	}
+	log.Printf("Appending %d redirects in %d shards to %s", len(idx.redirects), len(shards), filepath.Base(tarFile))
+	idx.mu.Unlock()
+
+	for i, shard := range shards {
+		data, err := json.Marshal(shard)
+		if err != nil {
+			return err
+		}
+		if err := tarball.AppendTarFile(tarFile, tarball.NewBytesFile(RedirectShardPath(i), data)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(RedirectsIndex{Shards: len(shards)})
+	if err != nil {
+		return err
+	}
+	return tarball.AppendTarFile(tarFile, tarball.NewBytesFile(path.Join(RedirectsDir, RedirectsIndexFile), data))
+}
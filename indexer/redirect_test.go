@@ -0,0 +1,146 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package indexer
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// emptyTar creates a tar file without entries, to append files to it.
+func emptyTar(t *testing.T) string {
+	t.Helper()
+	tarFile := filepath.Join(t.TempDir(), "mirror.tar")
+	f, err := os.Create(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := tar.NewWriter(f).Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tarFile
+}
+
+// readTar returns the files of the tar by name.
+func readTar(t *testing.T, tarFile string) map[string][]byte {
+	t.Helper()
+	f, err := os.Open(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[hdr.Name], err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func redirectsIndexer(n int) *SwarmZimIndexer {
+	idx := &SwarmZimIndexer{redirectMode: RedirectMap, redirects: make(map[string]string)}
+	for i := 0; i < n; i++ {
+		idx.redirects[fmt.Sprintf("A/Redirect_%d", i)] = fmt.Sprintf("A/Article_%d", i%10)
+	}
+	return idx
+}
+
+func TestMakeRedirectsMap(t *testing.T) {
+	idx := redirectsIndexer(2500)
+	tarFile := emptyTar(t)
+	if err := idx.MakeRedirectsMap(tarFile); err != nil {
+		t.Fatal(err)
+	}
+	files := readTar(t, tarFile)
+
+	var index RedirectsIndex
+	if err := json.Unmarshal(files[path.Join(RedirectsDir, RedirectsIndexFile)], &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Shards != 4 {
+		t.Fatalf("got %d shards for 2500 redirects, want 4", index.Shards)
+	}
+
+	var count int
+	for i := 0; i < index.Shards; i++ {
+		var shard map[string]string
+		if err := json.Unmarshal(files[RedirectShardPath(i)], &shard); err != nil {
+			t.Fatalf("shard %d: %v", i, err)
+		}
+		for from, to := range shard {
+			if RedirectShard(from, index.Shards) != i {
+				t.Errorf("redirect %s in shard %d, want %d", from, i, RedirectShard(from, index.Shards))
+			}
+			if idx.redirects[from] != to {
+				t.Errorf("got target %s of %s, want %s", to, from, idx.redirects[from])
+			}
+			count++
+		}
+	}
+	if count != len(idx.redirects) {
+		t.Errorf("got %d redirects in the shards, want %d", count, len(idx.redirects))
+	}
+	if _, ok := files[RedirectsMapFile]; ok {
+		t.Errorf("single %s written along the shards", RedirectsMapFile)
+	}
+}
+
+var errorPageScript = regexp.MustCompile(`(?s)<script>(.*)</script>`)
+
+// TestErrorPageRedirectShard runs the script of the error page with node, to
+// check it downloads the shard the indexer wrote the redirect to.
+func TestErrorPageRedirectShard(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	idx := redirectsIndexer(5000)
+	tarFile := emptyTar(t)
+	if err := idx.MakeErrorPage(tarFile); err != nil {
+		t.Fatal(err)
+	}
+	match := errorPageScript.FindSubmatch(readTar(t, tarFile)["error.html"])
+	if match == nil {
+		t.Fatal("error page has no script")
+	}
+
+	shards := redirectShards(len(idx.redirects))
+	for _, page := range []string{"A/Redirect_42", "A/Ærø_(Dänemark)", "I/favicon.png"} {
+		stubs := fmt.Sprintf(`
+			var window = {location: {pathname: "/bzz/0123/" + encodeURIComponent(%q), search: "", hash: ""}};
+			var document = {getElementById: function () { return {}; }};
+			var fetch = function (url) { console.log(url); return new Promise(function () {}); };
+		`, page)
+		out, err := exec.Command(node, "-e", stubs+string(match[1])).CombinedOutput()
+		if err != nil {
+			t.Fatalf("run error page script: %v: %s", err, out)
+		}
+		want := "/bzz/0123/" + RedirectShardPath(RedirectShard(page, shards))
+		if got := strings.TrimSpace(string(out)); got != want {
+			t.Errorf("error page of %s fetches %s, want %s", page, got, want)
+		}
+	}
+}
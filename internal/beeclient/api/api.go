@@ -50,6 +50,7 @@ type UploadOptions struct {
 	Pin     bool
 	Tag     uint32
 	BatchID string
+	Encrypt bool
 }
 
 type UploadCollectionOptions struct {
@@ -59,4 +60,5 @@ type UploadCollectionOptions struct {
 	BatchID             string
 	IndexDocumentHeader string
 	ErrorDocumentHeader string
+	Encrypt             bool
 }
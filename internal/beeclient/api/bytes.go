@@ -17,13 +17,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/r0qs/beezim/internal/httpclient"
 )
 
 // Download downloads data from the node
 func (a *Api) DownloadBytes(ctx context.Context, addr swarm.Address) (resp io.ReadCloser, err error) {
-	return a.C.RequestData(ctx, http.MethodGet, fmt.Sprintf("/bytes/%s", addr.String()), nil)
+	return a.C.RequestData(httpclient.WithTimeout(ctx, 0), http.MethodGet, fmt.Sprintf("/bytes/%s", addr.String()), nil)
 }
 
 // BytesUploadResponse represents Upload's response
@@ -37,9 +39,18 @@ func (a *Api) UploadBytes(ctx context.Context, data io.Reader, o UploadOptions)
 
 	header := make(http.Header)
 	header.Set("Content-Type", "application/octet-stream")
+	header.Set(SwarmDeferredUploadHeader, "true")
+	header.Set(SwarmPostageBatchIdHeader, o.BatchID)
 	if o.Pin {
 		header.Add(SwarmPinHeader, "true")
 	}
-	err := a.C.RequestWithHeader(ctx, http.MethodPost, "/bytes", header, data, &resp)
+	if o.Tag != 0 {
+		header.Set(SwarmTagHeader, strconv.FormatUint(uint64(o.Tag), 10))
+	}
+	if o.Encrypt {
+		header.Set(SwarmEncryptHeader, "true")
+	}
+	// the upload of the data takes as long as it needs
+	err := a.C.RequestWithHeader(httpclient.WithTimeout(ctx, 0), http.MethodPost, "/bytes", header, data, &resp)
 	return resp, err
 }
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/ethersphere/bee/pkg/swarm"
@@ -44,6 +45,9 @@ func (a *Api) UploadChunk(ctx context.Context, data []byte, o UploadOptions) (Ch
 		header.Add(SwarmPinHeader, "true")
 	}
 	header.Add(SwarmPostageBatchIdHeader, o.BatchID)
+	if o.Tag != 0 {
+		header.Set(SwarmTagHeader, strconv.FormatUint(uint64(o.Tag), 10))
+	}
 
 	err := a.C.RequestWithHeader(ctx, http.MethodPost, "/chunks", header, bytes.NewReader(data), &resp)
 	return resp, err
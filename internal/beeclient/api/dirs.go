@@ -17,14 +17,27 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/ethersphere/bee/pkg/swarm"
+	"github.com/r0qs/beezim/internal/httpclient"
 )
 
 // DownloadCollection downloads data from the node
 func (a *Api) DownloadCollection(ctx context.Context, addr swarm.Address, path string) (resp io.ReadCloser, err error) {
-	return a.C.RequestData(ctx, http.MethodGet, fmt.Sprintf("/bzz/%s/%s", addr.String(), path), nil)
+	return a.C.RequestData(httpclient.WithTimeout(ctx, 0), http.MethodGet, fmt.Sprintf("/bzz/%s/%s", addr.String(), escapePath(path)), nil)
+}
+
+// escapePath escapes each segment of the path of a collection entry,
+// whose names may contain characters like "?" or "#".
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
 }
 
 // DirsUploadResponse represents Upload's response
@@ -64,6 +77,11 @@ func (a *Api) UploadCollection(ctx context.Context, data io.Reader, size int64,
 		header.Set(SwarmTagHeader, strconv.FormatUint(uint64(o.Tag), 10))
 	}
 
-	err := a.C.RequestWithHeader(ctx, http.MethodPost, "/bzz", header, data, &resp)
+	if o.Encrypt {
+		header.Set(SwarmEncryptHeader, "true")
+	}
+
+	// the upload of a collection takes as long as it needs
+	err := a.C.RequestWithHeader(httpclient.WithTimeout(ctx, 0), http.MethodPost, "/bzz", header, data, &resp)
 	return resp, err
 }
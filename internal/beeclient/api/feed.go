@@ -0,0 +1,86 @@
+// Copyright 2021 Ethersphere.
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is originally governed by
+// BSD 3-Clause and our modifications by GPLv3
+// license that can be found in the LICENSE file.
+//
+// This code is based on the beekeeper beeclient api.
+// The http client was split to its own package.
+// The bee api and debug api were modified and
+// simplified to fit the purposes of Beezim.
+
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// FeedReferenceResponse represents the reference of a feed manifest or update
+type FeedReferenceResponse struct {
+	Reference swarm.Address `json:"reference"`
+}
+
+// FeedUpdateResponse represents the latest update of a sequential feed
+type FeedUpdateResponse struct {
+	Reference swarm.Address
+	Index     uint64
+	NextIndex uint64
+}
+
+// CreateFeedManifest creates the manifest of a sequential feed, whose reference
+// resolves to the content referenced by the latest feed update.
+func (a *Api) CreateFeedManifest(ctx context.Context, owner, topic string, o UploadOptions) (FeedReferenceResponse, error) {
+	var resp FeedReferenceResponse
+
+	header := make(http.Header)
+	if o.Pin {
+		header.Add(SwarmPinHeader, "true")
+	}
+	header.Add(SwarmPostageBatchIdHeader, o.BatchID)
+
+	url := fmt.Sprintf("/feeds/%s/%s?type=sequence", owner, topic)
+	err := a.C.RequestWithHeader(ctx, http.MethodPost, url, header, nil, &resp)
+	return resp, err
+}
+
+// FindFeedUpdate looks up the latest update of a sequential feed
+func (a *Api) FindFeedUpdate(ctx context.Context, owner, topic string) (FeedUpdateResponse, error) {
+	var ref FeedReferenceResponse
+
+	url := fmt.Sprintf("/feeds/%s/%s?type=sequence", owner, topic)
+	h, err := a.C.RequestWithHeaderResponse(ctx, http.MethodGet, url, make(http.Header), nil, &ref)
+	if err != nil {
+		return FeedUpdateResponse{}, err
+	}
+
+	index, err := decodeFeedIndex(h.Get(SwarmFeedIndexHeader))
+	if err != nil {
+		return FeedUpdateResponse{}, err
+	}
+	next, err := decodeFeedIndex(h.Get(SwarmFeedIndexNextHeader))
+	if err != nil {
+		return FeedUpdateResponse{}, err
+	}
+
+	return FeedUpdateResponse{
+		Reference: ref.Reference,
+		Index:     index,
+		NextIndex: next,
+	}, nil
+}
+
+// decodeFeedIndex decodes a hex encoded big endian sequential feed index
+func decodeFeedIndex(s string) (uint64, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 8 {
+		return 0, fmt.Errorf("invalid feed index %q", s)
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
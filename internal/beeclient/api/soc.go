@@ -0,0 +1,38 @@
+// Copyright 2021 Ethersphere.
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is originally governed by
+// BSD 3-Clause and our modifications by GPLv3
+// license that can be found in the LICENSE file.
+//
+// This code is based on the beekeeper beeclient api.
+// The http client was split to its own package.
+// The bee api and debug api were modified and
+// simplified to fit the purposes of Beezim.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// UploadSOC uploads a single owner chunk. The owner and id are hex encoded and
+// the signature is the owner's signature of the id and the wrapped chunk address.
+// The data is the span and payload of the wrapped content addressed chunk.
+func (a *Api) UploadSOC(ctx context.Context, owner, id, signature string, data []byte, o UploadOptions) (ChunksUploadResponse, error) {
+	var resp ChunksUploadResponse
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/octet-stream")
+	if o.Pin {
+		header.Add(SwarmPinHeader, "true")
+	}
+	header.Add(SwarmPostageBatchIdHeader, o.BatchID)
+
+	url := fmt.Sprintf("/soc/%s/%s?sig=%s", owner, id, signature)
+	err := a.C.RequestWithHeader(ctx, http.MethodPost, url, header, bytes.NewReader(data), &resp)
+	return resp, err
+}
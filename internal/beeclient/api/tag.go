@@ -0,0 +1,42 @@
+// Copyright 2021 Ethersphere.
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is originally governed by
+// BSD 3-Clause and our modifications by GPLv3
+// license that can be found in the LICENSE file.
+//
+// This code is based on the beekeeper beeclient api.
+// The http client was split to its own package.
+// The bee api and debug api were modified and
+// simplified to fit the purposes of Beezim.
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// TagResponse represents a tag as returned by the api
+type TagResponse struct {
+	Uid       uint32    `json:"uid"`
+	StartedAt time.Time `json:"startedAt"`
+	Total     int64     `json:"total"`
+	Processed int64     `json:"processed"`
+	Synced    int64     `json:"synced"`
+}
+
+// CreateTag creates a new tag to track the chunks of an upload
+func (a *Api) CreateTag(ctx context.Context) (TagResponse, error) {
+	var resp TagResponse
+	err := a.C.RequestJSON(ctx, http.MethodPost, "/tags", nil, &resp)
+	return resp, err
+}
+
+// GetTag fetches a tag by its uid
+func (a *Api) GetTag(ctx context.Context, uid uint32) (TagResponse, error) {
+	var resp TagResponse
+	err := a.C.Request(ctx, http.MethodGet, fmt.Sprintf("/tags/%d", uid), nil, &resp)
+	return resp, err
+}
@@ -14,10 +14,12 @@ package beeclient
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/r0qs/beezim/internal/beeclient/api"
 	"github.com/r0qs/beezim/internal/beeclient/debugapi"
@@ -32,6 +34,12 @@ type ClientOptions struct {
 	APIInsecureTLS      bool
 	DebugAPIURL         *url.URL
 	DebugAPIInsecureTLS bool
+	// Retry is the retry policy of the requests to the node,
+	// httpclient.DefaultRetryOptions if nil.
+	Retry *httpclient.RetryOptions
+	// RequestTimeout limits each attempt of a request, except the
+	// uploads and downloads of data. Zero means no timeout.
+	RequestTimeout time.Duration
 }
 
 type BeeClient struct {
@@ -51,6 +59,8 @@ func NewBee(opts ClientOptions) (c *BeeClient, err error) {
 					},
 				},
 			},
+			Retry:   opts.Retry,
+			Timeout: opts.RequestTimeout,
 		})
 		if err != nil {
 			return nil, err
@@ -65,6 +75,8 @@ func NewBee(opts ClientOptions) (c *BeeClient, err error) {
 					},
 				},
 			},
+			Retry:   opts.Retry,
+			Timeout: opts.RequestTimeout,
 		})
 		if err != nil {
 			return nil, err
@@ -106,6 +118,7 @@ func (c *BeeClient) DownloadManifestFile(ctx context.Context, addr swarm.Address
 	if err != nil {
 		return 0, nil, fmt.Errorf("download manifest file %s: %w", path, err)
 	}
+	defer r.Close()
 
 	h := tarball.FileHasher()
 	size, err = io.Copy(h, r)
@@ -147,3 +160,109 @@ func (c *BeeClient) CreatePostageBatch(ctx context.Context, amount int64, depth
 func (c *BeeClient) PostageBatches(ctx context.Context) ([]debugapi.PostageStampResponse, error) {
 	return c.debug.PostageBatches(ctx)
 }
+
+// TopUpPostageBatch increases the amount per chunk of a batch, extending its TTL
+func (c *BeeClient) TopUpPostageBatch(ctx context.Context, batchID string, amount int64, o debugapi.PostageOptions) (string, error) {
+	return c.debug.TopUpPostageBatch(ctx, batchID, amount, o)
+}
+
+// DilutePostageBatch increases the depth of a batch, doubling its capacity
+// and halving its TTL for each extra level
+func (c *BeeClient) DilutePostageBatch(ctx context.Context, batchID string, depth uint64, o debugapi.PostageOptions) (string, error) {
+	return c.debug.DilutePostageBatch(ctx, batchID, depth, o)
+}
+
+// PostageBatch returns the postage stamp batch with the given ID
+func (c *BeeClient) PostageBatch(ctx context.Context, batchID string) (debugapi.PostageStampResponse, error) {
+	return c.debug.PostageBatch(ctx, batchID)
+}
+
+// ChainState returns the state of the postage contract
+func (c *BeeClient) ChainState(ctx context.Context) (debugapi.ChainStateResponse, error) {
+	return c.debug.ChainState(ctx)
+}
+
+// WaitPostageBatchUsable polls the node until the batch is usable to stamp chunks
+func (c *BeeClient) WaitPostageBatchUsable(ctx context.Context, batchID string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		batch, err := c.debug.PostageBatch(ctx, batchID)
+		// a newly bought batch is unknown until the node sees the transaction
+		if err != nil && !errors.Is(err, httpclient.ErrNotFound) {
+			return fmt.Errorf("wait postage batch %s: %w", batchID, err)
+		}
+		if err == nil && batch.Usable {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait postage batch %s: %w", batchID, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// CreateTag creates a new tag to track the chunks of an upload
+func (c *BeeClient) CreateTag(ctx context.Context) (uint32, error) {
+	resp, err := c.api.CreateTag(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("create tag: %w", err)
+	}
+	return resp.Uid, nil
+}
+
+// GetTag returns the chunk counters of a tag. Without the debug api,
+// only the total, stored and synced counters are known.
+func (c *BeeClient) GetTag(ctx context.Context, uid uint32) (debugapi.TagResponse, error) {
+	if c.debug != nil {
+		return c.debug.GetTag(ctx, uid)
+	}
+
+	resp, err := c.api.GetTag(ctx, uid)
+	if err != nil {
+		return debugapi.TagResponse{}, err
+	}
+	return debugapi.TagResponse{
+		Uid:       resp.Uid,
+		StartedAt: resp.StartedAt,
+		Total:     resp.Total,
+		Split:     resp.Total,
+		Stored:    resp.Processed,
+		Synced:    resp.Synced,
+	}, nil
+}
+
+// TagSynced returns how many chunks of the tag are already in the network,
+// either pushed by the node or seen as already stored.
+func TagSynced(tag debugapi.TagResponse) int64 {
+	return tag.Synced + tag.Seen
+}
+
+// WaitSynced polls the tag until all of its chunks are synced to the network,
+// calling progress with each fetched state of the tag.
+func (c *BeeClient) WaitSynced(ctx context.Context, uid uint32, interval time.Duration, progress func(debugapi.TagResponse)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		tag, err := c.GetTag(ctx, uid)
+		if err != nil {
+			return fmt.Errorf("wait tag %d synced: %w", uid, err)
+		}
+		if progress != nil {
+			progress(tag)
+		}
+		if tag.Split > 0 && TagSynced(tag) >= tag.Split {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait tag %d synced: %w", uid, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -73,3 +73,51 @@ func (d *DebugAPI) PostageBatches(ctx context.Context) ([]PostageStampResponse,
 	}
 	return resp.Stamps, nil
 }
+
+// PostageBatch fetches a postage stamp batch by its ID
+func (d *DebugAPI) PostageBatch(ctx context.Context, batchID string) (PostageStampResponse, error) {
+	var resp PostageStampResponse
+	err := d.C.Request(ctx, http.MethodGet, "/stamps/"+batchID, nil, &resp)
+	return resp, err
+}
+
+type ChainStateResponse struct {
+	Block        uint64         `json:"block"`
+	TotalAmount  *bigint.BigInt `json:"totalAmount"`
+	CurrentPrice *bigint.BigInt `json:"currentPrice"`
+}
+
+// ChainState fetches the state of the postage contract, including the
+// current price of storing a chunk per block
+func (d *DebugAPI) ChainState(ctx context.Context) (ChainStateResponse, error) {
+	var resp ChainStateResponse
+	err := d.C.Request(ctx, http.MethodGet, "/chainstate", nil, &resp)
+	return resp, err
+}
+
+// TopUpPostageBatch sends a topup request to increase the amount per chunk of a batch
+func (d *DebugAPI) TopUpPostageBatch(ctx context.Context, batchID string, amount int64, o PostageOptions) (string, error) {
+	url := fmt.Sprintf("/stamps/topup/%s/%d", batchID, amount)
+	return d.updatePostageBatch(ctx, url, o)
+}
+
+// DilutePostageBatch sends a dilute request to increase the depth of a batch
+func (d *DebugAPI) DilutePostageBatch(ctx context.Context, batchID string, depth uint64, o PostageOptions) (string, error) {
+	url := fmt.Sprintf("/stamps/dilute/%s/%d", batchID, depth)
+	return d.updatePostageBatch(ctx, url, o)
+}
+
+func (d *DebugAPI) updatePostageBatch(ctx context.Context, url string, o PostageOptions) (string, error) {
+	h := http.Header{}
+
+	if o.GasPrice != "" {
+		h.Add(api.GasPriceHeader, o.GasPrice)
+	}
+
+	var resp postageResponse
+	err := d.C.RequestWithHeader(ctx, http.MethodPatch, url, h, nil, &resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.BatchID, nil
+}
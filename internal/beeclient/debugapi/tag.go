@@ -0,0 +1,41 @@
+// Copyright 2021 Ethersphere.
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is originally governed by
+// BSD 3-Clause and our modifications by GPLv3
+// license that can be found in the LICENSE file.
+//
+// This code is based on the beekeeper beeclient api.
+// The http client was split to its own package.
+// The bee api and debug api were modified and
+// simplified to fit the purposes of Beezim.
+package debugapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// TagResponse represents a tag with the detailed chunk counters of the debug api
+type TagResponse struct {
+	Total     int64         `json:"total"`
+	Split     int64         `json:"split"`
+	Seen      int64         `json:"seen"`
+	Stored    int64         `json:"stored"`
+	Sent      int64         `json:"sent"`
+	Synced    int64         `json:"synced"`
+	Uid       uint32        `json:"uid"`
+	Address   swarm.Address `json:"address"`
+	StartedAt time.Time     `json:"startedAt"`
+}
+
+// GetTag fetches a tag by its uid
+func (d *DebugAPI) GetTag(ctx context.Context, uid uint32) (TagResponse, error) {
+	var resp TagResponse
+	err := d.C.Request(ctx, http.MethodGet, fmt.Sprintf("/tags/%d", uid), nil, &resp)
+	return resp, err
+}
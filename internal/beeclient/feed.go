@@ -0,0 +1,115 @@
+// Copyright 2021 Ethersphere.
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is originally governed by
+// BSD 3-Clause and our modifications by GPLv3
+// license that can be found in the LICENSE file.
+//
+// This code is based on the beekeeper beeclient api.
+// The http client was split to its own package.
+// The bee api and debug api were modified and
+// simplified to fit the purposes of Beezim.
+
+package beeclient
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/httpclient"
+
+	"github.com/ethersphere/bee/pkg/cac"
+	"github.com/ethersphere/bee/pkg/crypto"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// FeedTopic returns the topic of a feed given its name.
+func FeedTopic(name string) ([]byte, error) {
+	return crypto.LegacyKeccak256([]byte(name))
+}
+
+// UploadSOC wraps the payload in a content addressed chunk and uploads it
+// as a single owner chunk with the given id, signed by the signer.
+func (c *BeeClient) UploadSOC(ctx context.Context, signer crypto.Signer, id []byte, payload []byte, o api.UploadOptions) (swarm.Address, error) {
+	ch, err := cac.New(payload)
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	owner, err := signer.EthereumAddress()
+	if err != nil {
+		return swarm.Address{}, err
+	}
+
+	digest, err := crypto.LegacyKeccak256(append(append([]byte{}, id...), ch.Address().Bytes()...))
+	if err != nil {
+		return swarm.Address{}, err
+	}
+	sig, err := signer.Sign(digest)
+	if err != nil {
+		return swarm.Address{}, fmt.Errorf("sign soc: %w", err)
+	}
+
+	resp, err := c.api.UploadSOC(ctx, hex.EncodeToString(owner.Bytes()), hex.EncodeToString(id), hex.EncodeToString(sig), ch.Data(), o)
+	if err != nil {
+		return swarm.Address{}, fmt.Errorf("upload soc: %w", err)
+	}
+	return resp.Reference, nil
+}
+
+// FeedUpdate returns the latest update of a sequential feed.
+func (c *BeeClient) FeedUpdate(ctx context.Context, owner []byte, topic []byte) (api.FeedUpdateResponse, error) {
+	return c.api.FindFeedUpdate(ctx, hex.EncodeToString(owner), hex.EncodeToString(topic))
+}
+
+// UpdateFeed appends an update pointing to the reference to the sequential
+// feed of the signer and returns the index of the new update.
+func (c *BeeClient) UpdateFeed(ctx context.Context, signer crypto.Signer, topic []byte, ref swarm.Address, o api.UploadOptions) (uint64, error) {
+	owner, err := signer.EthereumAddress()
+	if err != nil {
+		return 0, err
+	}
+
+	var index uint64
+	latest, err := c.FeedUpdate(ctx, owner.Bytes(), topic)
+	switch {
+	case err == nil:
+		index = latest.NextIndex
+	case errors.Is(err, httpclient.ErrNotFound):
+		// the feed was never updated
+	default:
+		return 0, fmt.Errorf("lookup feed: %w", err)
+	}
+
+	indexBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(indexBytes, index)
+	id, err := crypto.LegacyKeccak256(append(append([]byte{}, topic...), indexBytes...))
+	if err != nil {
+		return 0, err
+	}
+
+	// a feed update is the timestamp of the update followed by the reference
+	payload := make([]byte, 8, 8+len(ref.Bytes()))
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Unix()))
+	payload = append(payload, ref.Bytes()...)
+
+	if _, err := c.UploadSOC(ctx, signer, id, payload, o); err != nil {
+		return 0, fmt.Errorf("update feed: %w", err)
+	}
+	return index, nil
+}
+
+// CreateFeedManifest creates the manifest of a sequential feed, whose
+// reference always resolves to the latest update of the feed.
+func (c *BeeClient) CreateFeedManifest(ctx context.Context, owner []byte, topic []byte, o api.UploadOptions) (swarm.Address, error) {
+	resp, err := c.api.CreateFeedManifest(ctx, hex.EncodeToString(owner), hex.EncodeToString(topic), o)
+	if err != nil {
+		return swarm.Address{}, fmt.Errorf("create feed manifest: %w", err)
+	}
+	return resp.Reference, nil
+}
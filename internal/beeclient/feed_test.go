@@ -0,0 +1,311 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package beeclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+	"github.com/r0qs/beezim/internal/httpclient"
+
+	"github.com/ethersphere/bee/pkg/cac"
+	"github.com/ethersphere/bee/pkg/crypto"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// feedNode is a bee api storing single owner chunks and resolving the
+// sequential feeds made of them, as bee does.
+type feedNode struct {
+	mu sync.Mutex
+	// socs maps the addresses of the single owner chunks
+	// to the span and payload of the chunk they wrap
+	socs map[string][]byte
+}
+
+func newFeedNode() *feedNode {
+	return &feedNode{socs: make(map[string][]byte)}
+}
+
+func keccak(data ...[]byte) []byte {
+	h, err := crypto.LegacyKeccak256(bytes.Join(data, nil))
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+func feedUpdateID(topic []byte, index uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, index)
+	return keccak(topic, b)
+}
+
+func (n *feedNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	owner, err1 := hex.DecodeString(parts[1])
+	id, err2 := hex.DecodeString(parts[2])
+	if err1 != nil || err2 != nil {
+		http.Error(w, `{"code":400,"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && parts[0] == "soc":
+		n.uploadSOC(w, r, owner, id)
+	case r.Method == http.MethodGet && parts[0] == "feeds":
+		n.feedUpdate(w, owner, id)
+	case r.Method == http.MethodPost && parts[0] == "feeds":
+		// the feed manifest only depends on the owner and topic
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"reference":"%x"}`, keccak([]byte("feed manifest"), owner, id))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// uploadSOC stores the single owner chunk if it is signed by its owner,
+// so the chunks stored by the tests are known to be signed correctly.
+func (n *feedNode) uploadSOC(w http.ResponseWriter, r *http.Request, owner, id []byte) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, `{"code":400,"message":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	ch, err := cac.NewWithDataSpan(data)
+	if err != nil {
+		http.Error(w, `{"code":400,"message":"invalid chunk"}`, http.StatusBadRequest)
+		return
+	}
+	sig, err := hex.DecodeString(r.URL.Query().Get("sig"))
+	if err != nil {
+		http.Error(w, `{"code":400,"message":"invalid signature"}`, http.StatusBadRequest)
+		return
+	}
+	pub, err := crypto.Recover(sig, keccak(id, ch.Address().Bytes()))
+	if err != nil {
+		http.Error(w, `{"code":401,"message":"invalid signature"}`, http.StatusUnauthorized)
+		return
+	}
+	signer, err := crypto.NewEthereumAddress(*pub)
+	if err != nil || !bytes.Equal(signer, owner) {
+		http.Error(w, `{"code":401,"message":"invalid signature"}`, http.StatusUnauthorized)
+		return
+	}
+
+	addr := keccak(id, owner)
+	n.socs[hex.EncodeToString(addr)] = data
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"reference":"%x"}`, addr)
+}
+
+// feedUpdate looks up the latest update of the sequential feed, whose
+// updates are the single owner chunks of the ids of consecutive indexes.
+func (n *feedNode) feedUpdate(w http.ResponseWriter, owner, topic []byte) {
+	var index uint64
+	for ; ; index++ {
+		if _, ok := n.socs[hex.EncodeToString(keccak(feedUpdateID(topic, index), owner))]; !ok {
+			break
+		}
+	}
+	if index == 0 {
+		http.Error(w, `{"code":404,"message":"not found"}`, http.StatusNotFound)
+		return
+	}
+
+	data := n.socs[hex.EncodeToString(keccak(feedUpdateID(topic, index-1), owner))]
+	payload := data[swarm.SpanSize:]
+	w.Header().Set(api.SwarmFeedIndexHeader, fmt.Sprintf("%016x", index-1))
+	w.Header().Set(api.SwarmFeedIndexNextHeader, fmt.Sprintf("%016x", index))
+	fmt.Fprintf(w, `{"reference":"%x"}`, payload[8:])
+}
+
+// update returns the payload of the update of the feed at the index.
+func (n *feedNode) update(owner, topic []byte, index uint64) ([]byte, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	data, ok := n.socs[hex.EncodeToString(keccak(feedUpdateID(topic, index), owner))]
+	if !ok {
+		return nil, false
+	}
+	return data[swarm.SpanSize:], true
+}
+
+func newFeedTestClient(t *testing.T, n *feedNode) *BeeClient {
+	t.Helper()
+	srv := httptest.NewServer(n)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry := httpclient.DefaultRetryOptions()
+	retry.MaxAttempts = 1
+	c, err := NewBee(ClientOptions{APIURL: u, Retry: &retry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newTestSigner(t *testing.T) (crypto.Signer, []byte) {
+	t.Helper()
+	key, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := crypto.NewDefaultSigner(key)
+	owner, err := signer.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, owner.Bytes()
+}
+
+func TestUploadSOC(t *testing.T) {
+	n := newFeedNode()
+	c := newFeedTestClient(t, n)
+	signer, owner := newTestSigner(t)
+	ctx := context.Background()
+
+	id := keccak([]byte("id"))
+	payload := []byte("payload")
+	addr, err := c.UploadSOC(ctx, signer, id, payload, api.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the address of a single owner chunk is the hash of its id and owner
+	if want := swarm.NewAddress(keccak(id, owner)); !addr.Equal(want) {
+		t.Errorf("got address %s, want %s", addr, want)
+	}
+	data := n.socs[addr.String()]
+	if !bytes.Equal(data[swarm.SpanSize:], payload) || binary.LittleEndian.Uint64(data[:swarm.SpanSize]) != uint64(len(payload)) {
+		t.Errorf("got chunk %x, want the span and payload of %q", data, payload)
+	}
+
+	// the same id signed by another owner is another chunk
+	other, otherOwner := newTestSigner(t)
+	otherAddr, err := c.UploadSOC(ctx, other, id, payload, api.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := swarm.NewAddress(keccak(id, otherOwner)); !otherAddr.Equal(want) || otherAddr.Equal(addr) {
+		t.Errorf("got address %s of the chunk of another owner, want %s", otherAddr, want)
+	}
+
+	// the stand-in refuses a chunk not signed by its owner, as bee does
+	ch, err := cac.New(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := other.Sign(keccak(id, ch.Address().Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.api.UploadSOC(ctx, hex.EncodeToString(owner), hex.EncodeToString(id), hex.EncodeToString(sig), ch.Data(), api.UploadOptions{}); err == nil {
+		t.Error("got no error uploading a chunk signed by another key")
+	}
+}
+
+func TestUpdateFeed(t *testing.T) {
+	n := newFeedNode()
+	c := newFeedTestClient(t, n)
+	signer, owner := newTestSigner(t)
+	ctx := context.Background()
+
+	topic, err := FeedTopic("wikipedia_en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := keccak([]byte("wikipedia_en")); !bytes.Equal(topic, want) {
+		t.Errorf("got topic %x, want %x", topic, want)
+	}
+
+	if _, err := c.FeedUpdate(ctx, owner, topic); !errors.Is(err, httpclient.ErrNotFound) {
+		t.Fatalf("got error %v looking up a new feed, want %v", err, httpclient.ErrNotFound)
+	}
+
+	refs := []swarm.Address{
+		swarm.NewAddress(keccak([]byte("first mirror"))),
+		swarm.NewAddress(keccak([]byte("second mirror"))),
+		// the reference of an encrypted mirror is 64 bytes long
+		swarm.NewAddress(append(keccak([]byte("encrypted mirror")), keccak([]byte("key"))...)),
+	}
+	for i, ref := range refs {
+		before := time.Now().Unix()
+		index, err := c.UpdateFeed(ctx, signer, topic, ref, api.UploadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != uint64(i) {
+			t.Errorf("got index %d of update %d, want %d", index, i, i)
+		}
+
+		// the payload of an update is its timestamp followed by the reference
+		payload, ok := n.update(owner, topic, index)
+		if !ok {
+			t.Fatalf("update %d not stored at its index", i)
+		}
+		if ts := int64(binary.BigEndian.Uint64(payload[:8])); ts < before || ts > time.Now().Unix() {
+			t.Errorf("got timestamp %d of update %d, want the time of the update", ts, i)
+		}
+		if !bytes.Equal(payload[8:], ref.Bytes()) {
+			t.Errorf("got reference %x in update %d, want %s", payload[8:], i, ref)
+		}
+
+		latest, err := c.FeedUpdate(ctx, owner, topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest.Index != index || latest.NextIndex != index+1 || !latest.Reference.Equal(ref) {
+			t.Errorf("got latest update %+v, want index %d pointing to %s", latest, index, ref)
+		}
+	}
+}
+
+func TestCreateFeedManifest(t *testing.T) {
+	n := newFeedNode()
+	c := newFeedTestClient(t, n)
+	_, owner := newTestSigner(t)
+	ctx := context.Background()
+
+	topic, err := FeedTopic("wikipedia_en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.CreateFeedManifest(ctx, owner, topic, api.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.CreateFeedManifest(ctx, owner, topic, api.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.IsZero() || !first.Equal(second) {
+		t.Errorf("got feed manifests %s and %s, want the same one", first, second)
+	}
+}
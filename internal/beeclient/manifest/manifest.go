@@ -0,0 +1,299 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+// Package manifest builds mantaray manifests on the client and uploads their
+// nodes to a bee node, so collections can be created without a tar upload.
+// The manifests are built like bee builds the manifest of a tar upload,
+// so the same entries give the same reference, as long as the client and the
+// bee node agree on the MIME types of the extensions of the paths: both take
+// them from the Go mime package, whose table is extended by the mime.types
+// files of their host.
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+
+	"github.com/ethersphere/bee/pkg/cac"
+	"github.com/ethersphere/bee/pkg/manifest/mantaray"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// Metadata keys set by bee on the entries of a collection.
+const (
+	RootPath                      = "/"
+	WebsiteIndexDocumentSuffixKey = "website-index-document"
+	WebsiteErrorDocumentPathKey   = "website-error-document"
+	EntryMetadataContentTypeKey   = "Content-Type"
+	EntryMetadataFilenameKey      = "Filename"
+)
+
+// ErrNotFound is returned when a path has no entry in the manifest.
+var ErrNotFound = errors.New("manifest: not found")
+
+// Uploader stores the data of the nodes of a manifest.
+// It is implemented by beeclient.BeeClient.
+type Uploader interface {
+	UploadChunk(ctx context.Context, data []byte, o api.UploadOptions) (swarm.Address, error)
+	UploadBytes(ctx context.Context, data io.Reader, o api.UploadOptions) (swarm.Address, error)
+	DownloadBytes(ctx context.Context, addr swarm.Address) (io.ReadCloser, error)
+}
+
+// Builder adds entries to a mantaray manifest and stores it.
+type Builder struct {
+	trie *mantaray.Node
+	ls   *loadSaver
+	root map[string]string
+}
+
+// New returns a builder of an empty manifest whose nodes are uploaded with
+// the given options. The nodes of an encrypted manifest are obfuscated with
+// random keys and its entries are the 64 bytes references of encrypted data.
+func New(u Uploader, o api.UploadOptions) *Builder {
+	trie := mantaray.New()
+	// bee uses an empty obfuscation key for unencrypted manifests
+	if !o.Encrypt {
+		trie.SetObfuscationKey(mantaray.ZeroObfuscationKey)
+	}
+	return &Builder{
+		trie: trie,
+		ls:   &loadSaver{u: u, o: o},
+	}
+}
+
+// Open returns a builder updating the manifest with the given reference.
+// The manifest is loaded and rebuilt in memory, since the nodes of a loaded
+// mantaray trie keep their references when entries are added below them.
+func Open(ctx context.Context, u Uploader, ref swarm.Address, o api.UploadOptions) (*Builder, error) {
+	b := New(u, o)
+
+	err := mantaray.NewNodeRef(ref.Bytes()).WalkNode(ctx, []byte{}, b.ls, func(p []byte, node *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() {
+			return nil
+		}
+		return b.trie.Add(ctx, p, node.Entry(), node.Metadata(), b.ls)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("manifest: open %s: %w", ref, err)
+	}
+	return b, nil
+}
+
+// Entry describes the content stored at a path of the manifest.
+type Entry struct {
+	Reference swarm.Address
+	// ContentType defaults to the type of the extension of the path
+	// in the MIME table of the host, as bee sets it for tar uploads.
+	ContentType string
+	// Metadata holds custom metadata keys of the entry.
+	Metadata map[string]string
+}
+
+// Add adds the entry at the path, replacing any previous one.
+func (b *Builder) Add(ctx context.Context, p string, e Entry) error {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return fmt.Errorf("manifest: empty path")
+	}
+
+	metadata := make(map[string]string, len(e.Metadata)+2)
+	for k, v := range e.Metadata {
+		metadata[k] = v
+	}
+	metadata[EntryMetadataContentTypeKey] = e.ContentType
+	if e.ContentType == "" {
+		metadata[EntryMetadataContentTypeKey] = mime.TypeByExtension(path.Ext(p))
+	}
+	metadata[EntryMetadataFilenameKey] = path.Base(p)
+
+	if err := b.trie.Add(ctx, []byte(p), e.Reference.Bytes(), metadata, b.ls); err != nil {
+		return fmt.Errorf("manifest: add %s: %w", p, err)
+	}
+	return nil
+}
+
+// Remove removes the entry at the path.
+func (b *Builder) Remove(ctx context.Context, p string) error {
+	err := b.trie.Remove(ctx, []byte(strings.TrimPrefix(p, "/")), b.ls)
+	if errors.Is(err, mantaray.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Lookup returns the entry at the path.
+func (b *Builder) Lookup(ctx context.Context, p string) (Entry, error) {
+	return lookup(ctx, b.trie, b.ls, p)
+}
+
+// SetIndexDocument sets the document served for the paths of directories.
+func (b *Builder) SetIndexDocument(name string) error {
+	if strings.ContainsRune(name, '/') {
+		return fmt.Errorf("manifest: index document %s must not include a slash", name)
+	}
+	b.SetRootMetadata(WebsiteIndexDocumentSuffixKey, name)
+	return nil
+}
+
+// SetErrorDocument sets the document served for the paths without entry.
+func (b *Builder) SetErrorDocument(name string) {
+	b.SetRootMetadata(WebsiteErrorDocumentPathKey, name)
+}
+
+// SetRootMetadata sets a metadata key of the root of the manifest.
+func (b *Builder) SetRootMetadata(key, value string) {
+	if b.root == nil {
+		b.root = make(map[string]string)
+	}
+	b.root[key] = value
+}
+
+// Store uploads the nodes of the manifest changed since it was last stored
+// and returns its reference.
+func (b *Builder) Store(ctx context.Context) (swarm.Address, error) {
+	if len(b.root) > 0 {
+		// keep the root metadata of an opened manifest
+		if node, err := b.trie.LookupNode(ctx, []byte(RootPath), b.ls); err == nil {
+			for k, v := range node.Metadata() {
+				if _, ok := b.root[k]; !ok {
+					b.root[k] = v
+				}
+			}
+		}
+		if err := b.trie.Add(ctx, []byte(RootPath), swarm.ZeroAddress.Bytes(), b.root, b.ls); err != nil {
+			return swarm.ZeroAddress, fmt.Errorf("manifest: add root metadata: %w", err)
+		}
+		b.root = nil
+	}
+
+	if err := b.trie.Save(ctx, b.ls); err != nil {
+		return swarm.ZeroAddress, fmt.Errorf("manifest: store: %w", err)
+	}
+	return swarm.NewAddress(b.trie.Reference()), nil
+}
+
+// Reader looks up the entries of a stored manifest, loading its nodes only
+// when a lookup goes through them. It is safe for concurrent use.
+type Reader struct {
+	mu   sync.Mutex
+	trie *mantaray.Node
+	ls   *loadSaver
+}
+
+// NewReader returns a reader of the manifest with the given reference.
+func NewReader(u Uploader, ref swarm.Address) *Reader {
+	return &Reader{
+		trie: mantaray.NewNodeRef(ref.Bytes()),
+		ls:   &loadSaver{u: u},
+	}
+}
+
+// Lookup returns the entry at the path.
+func (r *Reader) Lookup(ctx context.Context, p string) (Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return lookup(ctx, r.trie, r.ls, p)
+}
+
+// Walk calls fn with the path and the entry of each file of the manifest,
+// loading each of its nodes once.
+func (r *Reader) Walk(ctx context.Context, fn func(p string, e Entry) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.trie.WalkNode(ctx, []byte{}, r.ls, func(p []byte, node *mantaray.Node, err error) error {
+		if err != nil {
+			return err
+		}
+		if !node.IsValueType() || len(p) == 0 {
+			return nil
+		}
+		return fn(string(p), nodeEntry(node))
+	})
+}
+
+func lookup(ctx context.Context, trie *mantaray.Node, ls *loadSaver, p string) (Entry, error) {
+	node, err := trie.LookupNode(ctx, []byte(strings.TrimPrefix(p, "/")), ls)
+	if errors.Is(err, mantaray.ErrNotFound) {
+		return Entry{}, ErrNotFound
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	if !node.IsValueType() {
+		return Entry{}, ErrNotFound
+	}
+	return nodeEntry(node), nil
+}
+
+// nodeEntry returns the entry of a value node.
+func nodeEntry(node *mantaray.Node) Entry {
+	e := Entry{
+		Reference: swarm.NewAddress(node.Entry()),
+		Metadata:  make(map[string]string),
+	}
+	for k, v := range node.Metadata() {
+		switch k {
+		case EntryMetadataContentTypeKey:
+			e.ContentType = v
+		case EntryMetadataFilenameKey:
+		default:
+			e.Metadata[k] = v
+		}
+	}
+	return e
+}
+
+// loadSaver stores the nodes of a manifest in a bee node. Nodes that fit in
+// a chunk are uploaded as a single chunk, bigger or encrypted ones with the
+// bytes api.
+type loadSaver struct {
+	u Uploader
+	o api.UploadOptions
+}
+
+func (ls *loadSaver) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	r, err := ls.u.DownloadBytes(ctx, swarm.NewAddress(ref))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (ls *loadSaver) Save(ctx context.Context, data []byte) ([]byte, error) {
+	if ls.o.Encrypt || len(data) > swarm.ChunkSize {
+		addr, err := ls.u.UploadBytes(ctx, bytes.NewReader(data), ls.o)
+		if err != nil {
+			return nil, err
+		}
+		return addr.Bytes(), nil
+	}
+
+	ch, err := cac.New(data)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := ls.u.UploadChunk(ctx, ch.Data(), ls.o)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.Equal(ch.Address()) {
+		return nil, fmt.Errorf("manifest: chunk stored as %s instead of %s", addr, ch.Address())
+	}
+	return addr.Bytes(), nil
+}
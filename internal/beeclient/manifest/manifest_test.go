@@ -0,0 +1,353 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/r0qs/beezim/internal/beeclient/api"
+
+	"github.com/ethersphere/bee/pkg/cac"
+	beemanifest "github.com/ethersphere/bee/pkg/manifest"
+	"github.com/ethersphere/bee/pkg/swarm"
+)
+
+// store is an Uploader keeping the chunks in memory. The fixtures and the
+// nodes of their manifests fit in a chunk, so the data is stored as single
+// content addressed chunks, whose address is the reference bee gives them.
+type store struct {
+	mu     sync.Mutex
+	chunks map[string][]byte
+}
+
+func newStore() *store {
+	return &store{chunks: make(map[string][]byte)}
+}
+
+func (s *store) UploadChunk(ctx context.Context, data []byte, o api.UploadOptions) (swarm.Address, error) {
+	ch, err := cac.NewWithDataSpan(data)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunks[ch.Address().String()] = data[swarm.SpanSize:]
+	return ch.Address(), nil
+}
+
+func (s *store) UploadBytes(ctx context.Context, data io.Reader, o api.UploadOptions) (swarm.Address, error) {
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	ch, err := cac.New(b)
+	if err != nil {
+		return swarm.ZeroAddress, err
+	}
+	return s.UploadChunk(ctx, ch.Data(), o)
+}
+
+func (s *store) DownloadBytes(ctx context.Context, addr swarm.Address) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.chunks[addr.String()]
+	if !ok {
+		return nil, fmt.Errorf("chunk %s not found", addr)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Load and Save make the store the file.LoadSaver of the bee manifests.
+func (s *store) Load(ctx context.Context, ref []byte) ([]byte, error) {
+	r, err := s.DownloadBytes(ctx, swarm.NewAddress(ref))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func (s *store) Save(ctx context.Context, data []byte) ([]byte, error) {
+	addr, err := s.UploadBytes(ctx, bytes.NewReader(data), api.UploadOptions{})
+	return addr.Bytes(), err
+}
+
+// fixtureTar returns the files of the directory as a tar file.
+func fixtureTar(t *testing.T, dir string) []byte {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, p := range files {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, _ := filepath.Rel(dir, p)
+		if err := tw.WriteHeader(&tar.Header{Name: filepath.ToSlash(name), Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// beeTarReference stores the tar file like the bzz api of bee stores a tar
+// upload, with its manifest package, and returns its reference.
+func beeTarReference(t *testing.T, s *store, data []byte, index, errorDoc string) swarm.Address {
+	t.Helper()
+	ctx := context.Background()
+	m, err := beemanifest.NewDefaultManifest(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := s.UploadBytes(ctx, tr, api.UploadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata := map[string]string{
+			beemanifest.EntryMetadataContentTypeKey: mime.TypeByExtension(filepath.Ext(hdr.Name)),
+			beemanifest.EntryMetadataFilenameKey:    hdr.FileInfo().Name(),
+		}
+		if err := m.Add(ctx, filepath.Clean(hdr.Name), beemanifest.NewEntry(ref, metadata)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metadata := map[string]string{
+		beemanifest.WebsiteIndexDocumentSuffixKey: index,
+		beemanifest.WebsiteErrorDocumentPathKey:   errorDoc,
+	}
+	if err := m.Add(ctx, beemanifest.RootPath, beemanifest.NewEntry(swarm.ZeroAddress, metadata)); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := m.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+// buildTarManifest uploads the files of the tar file one by one
+// and builds their manifest with the builder.
+func buildTarManifest(t *testing.T, s *store, data []byte, o api.UploadOptions) (*Builder, map[string]swarm.Address) {
+	t.Helper()
+	ctx := context.Background()
+	b := New(s, o)
+	refs := make(map[string]swarm.Address)
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := s.UploadBytes(ctx, tr, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Add(ctx, hdr.Name, Entry{Reference: ref}); err != nil {
+			t.Fatal(err)
+		}
+		refs[hdr.Name] = ref
+	}
+	return b, refs
+}
+
+func TestBuilderMatchesBeeTarUpload(t *testing.T) {
+	ctx := context.Background()
+	data := fixtureTar(t, filepath.Join("testdata", "site"))
+	s := newStore()
+
+	want := beeTarReference(t, s, data, "index.html", "error.html")
+
+	b, _ := buildTarManifest(t, s, data, api.UploadOptions{})
+	if err := b.SetIndexDocument("index.html"); err != nil {
+		t.Fatal(err)
+	}
+	b.SetErrorDocument("error.html")
+	got, err := b.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Equal(want) {
+		t.Fatalf("got manifest reference %s, want the reference %s of bee", got, want)
+	}
+}
+
+func TestOpenUpdatesManifest(t *testing.T) {
+	ctx := context.Background()
+	data := fixtureTar(t, filepath.Join("testdata", "site"))
+	s := newStore()
+
+	b, refs := buildTarManifest(t, s, data, api.UploadOptions{})
+	b.SetErrorDocument("error.html")
+	ref, err := b.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same change made to the stored manifest and to a new one
+	// gives the same reference
+	opened, err := Open(ctx, s, ref, api.UploadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opened.Remove(ctx, "A/Swarm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := opened.Add(ctx, "A/Bee", Entry{Reference: refs["A/Main_Page"]}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := opened.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := New(s, api.UploadOptions{})
+	for p, r := range refs {
+		if p == "A/Swarm" {
+			continue
+		}
+		if err := fresh.Add(ctx, p, Entry{Reference: r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fresh.Add(ctx, "A/Bee", Entry{Reference: refs["A/Main_Page"]}); err != nil {
+		t.Fatal(err)
+	}
+	fresh.SetErrorDocument("error.html")
+	want, err := fresh.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Equal(want) {
+		t.Fatalf("got reference %s of the updated manifest, want %s", got, want)
+	}
+	if err := opened.Remove(ctx, "A/Swarm"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v removing a missing entry, want %v", err, ErrNotFound)
+	}
+}
+
+func TestReaderLookup(t *testing.T) {
+	ctx := context.Background()
+	data := fixtureTar(t, filepath.Join("testdata", "site"))
+	s := newStore()
+
+	b, refs := buildTarManifest(t, s, data, api.UploadOptions{})
+	if err := b.Add(ctx, "A/Tagged", Entry{Reference: refs["A/Swarm"], ContentType: "text/html", Metadata: map[string]string{"Title": "Swarm"}}); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := b.Store(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(s, ref)
+	for p, want := range refs {
+		e, err := r.Lookup(ctx, p)
+		if err != nil {
+			t.Fatalf("lookup %s: %v", p, err)
+		}
+		if !e.Reference.Equal(want) {
+			t.Errorf("got reference %s for %s, want %s", e.Reference, p, want)
+		}
+	}
+
+	e, err := r.Lookup(ctx, "css/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ContentType != mime.TypeByExtension(".css") {
+		t.Errorf("got content type %q for css/style.css, want %q", e.ContentType, mime.TypeByExtension(".css"))
+	}
+
+	e, err = r.Lookup(ctx, "/A/Tagged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ContentType != "text/html" || e.Metadata["Title"] != "Swarm" {
+		t.Errorf("got content type %q and metadata %v for A/Tagged", e.ContentType, e.Metadata)
+	}
+
+	for _, p := range []string{"A/Missing", "A", "css/"} {
+		if _, err := r.Lookup(ctx, p); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v looking up %s, want %v", err, p, ErrNotFound)
+		}
+	}
+}
+
+func TestReaderWalk(t *testing.T) {
+	ctx := context.Background()
+	data := fixtureTar(t, filepath.Join("testdata", "site"))
+	s := newStore()
+
+	_, refs := buildTarManifest(t, s, data, api.UploadOptions{})
+	ref := beeTarReference(t, s, data, "index.html", "")
+
+	got := make(map[string]swarm.Address)
+	if err := NewReader(s, ref).Walk(ctx, func(p string, e Entry) error {
+		got[p] = e.Reference
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// the root entry holds the metadata of the website
+	delete(got, RootPath)
+	if len(got) != len(refs) {
+		t.Errorf("got %d entries, want %d", len(got), len(refs))
+	}
+	for p, want := range refs {
+		if !got[p].Equal(want) {
+			t.Errorf("got reference %s for %s, want %s", got[p], p, want)
+		}
+	}
+}
@@ -0,0 +1 @@
+document.title = document.title + " (mirror)";
@@ -13,16 +13,34 @@
 package beeclient
 
 import (
+	"fmt"
 	"math"
+	"math/big"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient/debugapi"
 
 	"github.com/ethersphere/bee/pkg/swarm"
 )
 
 const MinimumBatchDepth = 11
 
+// DefaultBucketDepth is the bucket depth of the batches created by bee.
+const DefaultBucketDepth = 16
+
+// BlockTime is the average time between blocks of the chain
+// where the postage contract is deployed.
+const BlockTime = 5 * time.Second
+
+// bzzDecimals is the number of decimals of the BZZ token, whose
+// smallest unit (PLUR) is the unit of the postage batch amount.
+const bzzDecimals = 16
+
+// EstimatePostageBatchDepth returns the depth of a batch large enough to
+// stamp all the chunks of the content and the number of chunks.
 func EstimatePostageBatchDepth(contentLength int64, isEncrypted bool) (uint64, int64) {
 	totalChunks := CalculateNumberOfChunks(contentLength, isEncrypted)
-	depth := uint64(math.Log2(float64(totalChunks)))
+	depth := RequiredBatchDepth(totalChunks, DefaultBucketDepth, 0)
 	if depth < MinimumBatchDepth {
 		depth = MinimumBatchDepth
 	}
@@ -51,3 +69,134 @@ func CalculateNumberOfChunks(contentLength int64, isEncrypted bool) int64 {
 
 	return int64(totalChunks) + 1
 }
+
+// EstimatePostageBatchAmount returns the amount per chunk needed to keep
+// a batch alive for the given time at the current price per chunk per block.
+func EstimatePostageBatchAmount(ttl time.Duration, pricePerBlock *big.Int) int64 {
+	blocks := int64(math.Ceil(float64(ttl) / float64(BlockTime)))
+	return new(big.Int).Mul(big.NewInt(blocks), pricePerBlock).Int64()
+}
+
+// PostageBatchTTL returns for how long a batch with the given amount
+// per chunk is kept alive at the current price per chunk per block.
+func PostageBatchTTL(amount int64, pricePerBlock *big.Int) time.Duration {
+	if pricePerBlock.Sign() <= 0 {
+		return 0
+	}
+	blocks := new(big.Int).Div(big.NewInt(amount), pricePerBlock)
+	return time.Duration(blocks.Int64()) * BlockTime
+}
+
+// PostageBatchCost returns the cost in BZZ of a batch, i.e.
+// the amount per chunk multiplied by the number of chunks.
+func PostageBatchCost(amount int64, depth uint64) *big.Float {
+	plur := new(big.Int).Lsh(big.NewInt(amount), uint(depth))
+	bzz := new(big.Int).Exp(big.NewInt(10), big.NewInt(bzzDecimals), nil)
+	return new(big.Float).Quo(new(big.Float).SetInt(plur), new(big.Float).SetInt(bzz))
+}
+
+// PostageBatchUsage returns the fraction of the batch already used, given by
+// its most filled bucket, which holds at most 2^(depth-bucketDepth) chunks.
+func PostageBatchUsage(depth uint8, bucketDepth uint8, utilization uint32) float64 {
+	if depth < bucketDepth {
+		return 0
+	}
+	return float64(utilization) / float64(uint64(1)<<(depth-bucketDepth))
+}
+
+// estimateBucketFill returns how many of the chunks are expected to fall into the
+// most filled bucket. The number of chunks per bucket follows a Poisson distribution,
+// so the estimate is the smallest count exceeded by less than 1% of the buckets.
+func estimateBucketFill(chunks int64, bucketDepth uint8) int64 {
+	buckets := float64(uint64(1) << bucketDepth)
+	mean := float64(chunks) / buckets
+
+	// for large means the Poisson is approximated by a normal distribution,
+	// where 5.2 standard deviations leave out 1% of 2^16 buckets
+	if mean > 500 {
+		return int64(math.Ceil(mean + 5.2*math.Sqrt(mean)))
+	}
+
+	p := math.Exp(-mean)
+	cdf := p
+	var k int64
+	for buckets*(1-cdf) > 0.01 {
+		k++
+		p *= mean / float64(k)
+		cdf += p
+	}
+	return k
+}
+
+// RequiredBatchDepth returns the minimum depth of a batch whose buckets can
+// stamp the given number of chunks on top of the ones already used.
+func RequiredBatchDepth(chunks int64, bucketDepth uint8, utilization uint32) uint64 {
+	fill := int64(utilization) + estimateBucketFill(chunks, bucketDepth)
+	depth := uint64(bucketDepth) + 1
+	for int64(1)<<(depth-uint64(bucketDepth)) < fill {
+		depth++
+	}
+	return depth
+}
+
+// BatchCapacityError is returned when a batch cannot stamp all chunks of an upload.
+type BatchCapacityError struct {
+	BatchID   string
+	Depth     uint8
+	Immutable bool
+	// Full is set when a bucket of an immutable batch is already full,
+	// so bee refuses to stamp any chunk falling into it.
+	Full bool
+	// Chunks is the number of chunks of the upload.
+	Chunks int64
+	// Needed and Capacity are the chunks the most filled bucket would need and hold.
+	Needed   int64
+	Capacity int64
+	// SuggestedDepth is the depth the batch should be diluted to.
+	SuggestedDepth uint64
+}
+
+func (e *BatchCapacityError) Error() string {
+	if e.Full {
+		return fmt.Sprintf("immutable postage batch %s of depth %d is full: its most filled bucket holds all its %d chunks and bee refuses to stamp more",
+			e.BatchID, e.Depth, e.Capacity)
+	}
+	consequence := "chunks stamped earlier with it would be overwritten"
+	if e.Immutable {
+		consequence = "the upload would fail once a bucket is full"
+	}
+	return fmt.Sprintf("postage batch %s of depth %d cannot stamp the %d chunks of the upload: its most filled bucket would need %d of %d slots and %s",
+		e.BatchID, e.Depth, e.Chunks, e.Needed, e.Capacity, consequence)
+}
+
+// CheckPostageBatchCapacity returns a *BatchCapacityError if the batch is not
+// expected to have enough free slots in its buckets to stamp the content.
+// Once a bucket of an immutable batch is full, the batch cannot stamp the
+// chunks falling into it, so it is refused whatever the size of the content.
+func CheckPostageBatchCapacity(batch debugapi.PostageStampResponse, contentLength int64, isEncrypted bool) error {
+	if !batch.Usable {
+		return fmt.Errorf("postage batch %s is not usable yet", batch.BatchID)
+	}
+	if batch.Depth < batch.BucketDepth {
+		return fmt.Errorf("postage batch %s has a depth %d lower than its bucket depth %d", batch.BatchID, batch.Depth, batch.BucketDepth)
+	}
+
+	chunks := CalculateNumberOfChunks(contentLength, isEncrypted)
+	capacity := int64(1) << (batch.Depth - batch.BucketDepth)
+	needed := int64(batch.Utilization) + estimateBucketFill(chunks, batch.BucketDepth)
+	full := batch.ImmutableFlag && int64(batch.Utilization) >= capacity
+	if needed <= capacity && !full {
+		return nil
+	}
+
+	return &BatchCapacityError{
+		BatchID:        batch.BatchID,
+		Depth:          batch.Depth,
+		Immutable:      batch.ImmutableFlag,
+		Full:           full,
+		Chunks:         chunks,
+		Needed:         needed,
+		Capacity:       capacity,
+		SuggestedDepth: RequiredBatchDepth(chunks, batch.BucketDepth, batch.Utilization),
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package beeclient
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/r0qs/beezim/internal/beeclient/debugapi"
+)
+
+func TestEstimatePostageBatchDepth(t *testing.T) {
+	for _, tc := range []struct {
+		contentLength int64
+		encrypted     bool
+		depth         uint64
+		chunks        int64
+	}{
+		{contentLength: 0, depth: 17, chunks: 1},
+		{contentLength: 4096, depth: 17, chunks: 1},
+		// two data chunks and their root
+		{contentLength: 4097, depth: 17, chunks: 3},
+		// 256 data chunks, 2 intermediate chunks and the root
+		{contentLength: 1 << 20, depth: 17, chunks: 259},
+		// encrypted references halve the branching factor
+		{contentLength: 1 << 20, encrypted: true, depth: 17, chunks: 261},
+		{contentLength: 1 << 30, depth: 21, chunks: 264209},
+		{contentLength: 10 << 30, depth: 23, chunks: 2642083},
+		{contentLength: 100 << 30, depth: 25, chunks: 26420814},
+		{contentLength: 100 << 30, encrypted: true, depth: 26, chunks: 26630503},
+	} {
+		depth, chunks := EstimatePostageBatchDepth(tc.contentLength, tc.encrypted)
+		if depth != tc.depth || chunks != tc.chunks {
+			t.Errorf("got depth %d and %d chunks for %d bytes (encrypted %t), want depth %d and %d chunks",
+				depth, chunks, tc.contentLength, tc.encrypted, tc.depth, tc.chunks)
+		}
+		if depth < MinimumBatchDepth {
+			t.Errorf("got depth %d below the minimum %d", depth, MinimumBatchDepth)
+		}
+		if err := CheckPostageBatchCapacity(debugapi.PostageStampResponse{
+			Usable: true, Depth: uint8(depth), BucketDepth: DefaultBucketDepth,
+		}, tc.contentLength, tc.encrypted); err != nil {
+			t.Errorf("estimated batch of depth %d cannot stamp %d bytes: %v", depth, tc.contentLength, err)
+		}
+	}
+}
+
+func TestEstimatePostageBatchAmount(t *testing.T) {
+	for _, tc := range []struct {
+		ttl    time.Duration
+		price  int64
+		amount int64
+	}{
+		{ttl: 0, price: 24000, amount: 0},
+		{ttl: BlockTime, price: 24000, amount: 24000},
+		// a partial block is paid in full
+		{ttl: 7 * time.Second, price: 24000, amount: 48000},
+		{ttl: 24 * time.Hour, price: 24000, amount: 17280 * 24000},
+		{ttl: 30 * 24 * time.Hour, price: 1, amount: 518400},
+		{ttl: 24 * time.Hour, price: 0, amount: 0},
+	} {
+		amount := EstimatePostageBatchAmount(tc.ttl, big.NewInt(tc.price))
+		if amount != tc.amount {
+			t.Errorf("got amount %d for %v at price %d, want %d", amount, tc.ttl, tc.price, tc.amount)
+		}
+		if tc.price == 0 {
+			continue
+		}
+		if ttl := PostageBatchTTL(amount, big.NewInt(tc.price)); ttl < tc.ttl || ttl >= tc.ttl+BlockTime {
+			t.Errorf("got ttl %v of amount %d at price %d, want %v rounded up to a block", ttl, amount, tc.price, tc.ttl)
+		}
+	}
+}
+
+func TestCheckPostageBatchCapacity(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		batch         debugapi.PostageStampResponse
+		contentLength int64
+		// capacityErr is set when a *BatchCapacityError is expected
+		capacityErr bool
+		full        bool
+		err         bool
+	}{
+		{
+			name:          "fits",
+			batch:         debugapi.PostageStampResponse{Usable: true, Depth: 20, BucketDepth: 16},
+			contentLength: 4096,
+		},
+		{
+			name:          "immutable with a free slot",
+			batch:         debugapi.PostageStampResponse{Usable: true, Depth: 20, BucketDepth: 16, Utilization: 15, ImmutableFlag: true},
+			contentLength: 4096,
+		},
+		{
+			name:          "not usable",
+			batch:         debugapi.PostageStampResponse{Depth: 20, BucketDepth: 16},
+			contentLength: 4096,
+			err:           true,
+		},
+		{
+			name:          "depth below bucket depth",
+			batch:         debugapi.PostageStampResponse{Usable: true, Depth: 15, BucketDepth: 16},
+			contentLength: 4096,
+			err:           true,
+		},
+		{
+			name:          "mutable overflow",
+			batch:         debugapi.PostageStampResponse{Usable: true, Depth: 20, BucketDepth: 16, Utilization: 16},
+			contentLength: 4096,
+			capacityErr:   true,
+		},
+		{
+			name:          "immutable full",
+			batch:         debugapi.PostageStampResponse{Usable: true, Depth: 20, BucketDepth: 16, Utilization: 16, ImmutableFlag: true},
+			contentLength: 4096,
+			capacityErr:   true,
+			full:          true,
+		},
+		{
+			name:          "immutable overflow",
+			batch:         debugapi.PostageStampResponse{Usable: true, Depth: 20, BucketDepth: 16, ImmutableFlag: true},
+			contentLength: 4096 << 21,
+			capacityErr:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckPostageBatchCapacity(tc.batch, tc.contentLength, false)
+			var capErr *BatchCapacityError
+			isCapErr := errors.As(err, &capErr)
+			switch {
+			case tc.capacityErr:
+				if !isCapErr {
+					t.Fatalf("got error %v, want a capacity error", err)
+				}
+				if capErr.Full != tc.full {
+					t.Errorf("got full %t, want %t", capErr.Full, tc.full)
+				}
+				if capErr.SuggestedDepth <= uint64(tc.batch.Depth) {
+					t.Errorf("got suggested depth %d, want more than %d", capErr.SuggestedDepth, tc.batch.Depth)
+				}
+			case tc.err:
+				if err == nil || isCapErr {
+					t.Errorf("got error %v, want an error other than a capacity error", err)
+				}
+			case err != nil:
+				t.Errorf("got error %v, want none", err)
+			}
+		})
+	}
+}
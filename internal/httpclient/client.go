@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 const contentType = "application/json; charset=utf-8"
@@ -29,10 +30,16 @@ const contentType = "application/json; charset=utf-8"
 type Client struct {
 	Host       string
 	HTTPClient *http.Client
+	Retry      RetryOptions
+	Timeout    time.Duration
 }
 
 type ClientOptions struct {
 	HTTPClient *http.Client
+	// Retry is the retry policy of the requests, DefaultRetryOptions if nil.
+	Retry *RetryOptions
+	// Timeout limits each attempt of a request, zero means no timeout.
+	Timeout time.Duration
 }
 
 func NewClient(u *url.URL, o *ClientOptions) (c *Client, err error) {
@@ -46,6 +53,11 @@ func NewClient(u *url.URL, o *ClientOptions) (c *Client, err error) {
 	}
 	c.HTTPClient = httpClientWithTransport(u, o.HTTPClient)
 	c.Host = u.Host
+	c.Retry = DefaultRetryOptions()
+	if o.Retry != nil {
+		c.Retry = *o.Retry
+	}
+	c.Timeout = o.Timeout
 
 	return c, nil
 }
@@ -97,11 +109,10 @@ func (c *Client) RequestJSON(ctx context.Context, method, path string, body, v i
 
 // request handles the HTTP request response cycle.
 func (c *Client) Request(ctx context.Context, method, path string, body io.Reader, v interface{}) (err error) {
-	req, err := http.NewRequest(method, path, body)
+	req, err := newRequest(method, path, body)
 	if err != nil {
 		return err
 	}
-	req = req.WithContext(ctx)
 
 	if body != nil {
 		req.Header.Set("Content-Type", contentType)
@@ -109,7 +120,7 @@ func (c *Client) Request(ctx context.Context, method, path string, body io.Reade
 	req.Header.Set("Accept", contentType)
 	req.Header.Set("User-Agent", "Mozilla/5.0 Firefox/86.0")
 
-	r, err := c.HTTPClient.Do(req)
+	r, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -127,11 +138,10 @@ func (c *Client) Request(ctx context.Context, method, path string, body io.Reade
 }
 
 func (c *Client) RequestWithResponseHeader(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, path, body)
+	req, err := newRequest(method, path, body)
 	if err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
 
 	if body != nil {
 		req.Header.Set("Content-Type", contentType)
@@ -139,7 +149,7 @@ func (c *Client) RequestWithResponseHeader(ctx context.Context, method, path str
 	req.Header.Set("Accept", contentType)
 	req.Header.Set("User-Agent", "Mozilla/5.0 Firefox/86.0")
 
-	r, err := c.HTTPClient.Do(req)
+	r, err := c.do(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -162,11 +172,10 @@ func encodeJSON(w io.Writer, v interface{}) (err error) {
 
 // requestData handles the HTTP request response cycle.
 func (c *Client) RequestData(ctx context.Context, method, path string, body io.Reader) (resp io.ReadCloser, err error) {
-	req, err := http.NewRequest(method, path, body)
+	req, err := newRequest(method, path, body)
 	if err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
 
 	if body != nil {
 		req.Header.Set("Content-Type", contentType)
@@ -174,7 +183,7 @@ func (c *Client) RequestData(ctx context.Context, method, path string, body io.R
 	req.Header.Set("Accept", contentType)
 	req.Header.Set("User-Agent", "Mozilla/5.0 Firefox/86.0")
 
-	r, err := c.HTTPClient.Do(req)
+	r, err := c.do(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -188,20 +197,20 @@ func (c *Client) RequestData(ctx context.Context, method, path string, body io.R
 
 // requestWithHeader handles the HTTP request response cycle.
 func (c *Client) RequestWithHeader(ctx context.Context, method, path string, header http.Header, body io.Reader, v interface{}) (err error) {
-	req, err := http.NewRequest(method, path, body)
+	req, err := newRequest(method, path, body)
 	if err != nil {
 		return err
 	}
-	req = req.WithContext(ctx)
 
 	req.Header = header
 	req.Header.Add("Accept", contentType)
 	req.Header.Set("User-Agent", "Mozilla/5.0 Firefox/86.0")
 
-	r, err := c.HTTPClient.Do(req)
+	r, err := c.do(ctx, req)
 	if err != nil {
 		return err
 	}
+	defer drain(r.Body)
 
 	if err = responseErrorHandler(r); err != nil {
 		return err
@@ -214,6 +223,37 @@ func (c *Client) RequestWithHeader(ctx context.Context, method, path string, hea
 	return
 }
 
+// RequestWithHeaderResponse handles the HTTP request response cycle like
+// RequestWithHeader, and also returns the headers of the response.
+func (c *Client) RequestWithHeaderResponse(ctx context.Context, method, path string, header http.Header, body io.Reader, v interface{}) (http.Header, error) {
+	req, err := newRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = header
+	req.Header.Add("Accept", contentType)
+	req.Header.Set("User-Agent", "Mozilla/5.0 Firefox/86.0")
+
+	r, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer drain(r.Body)
+
+	if err = responseErrorHandler(r); err != nil {
+		return nil, err
+	}
+
+	if v != nil && strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.Header, nil
+}
+
 // drain discards all of the remaining data from the reader and closes it,
 // asynchronously.
 func drain(r io.ReadCloser) {
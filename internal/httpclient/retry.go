@@ -0,0 +1,249 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package httpclient
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default retry policy of the client.
+const (
+	DefaultMaxAttempts = 5
+	DefaultMinBackoff  = 500 * time.Millisecond
+	DefaultMaxBackoff  = 30 * time.Second
+)
+
+// RetryOptions configures how failed requests are retried.
+//
+// A request is retried on network errors and when the node answers that it
+// is busy or recovering the data (statuses 202, 429, 502, 503 and 504), as
+// long as it is idempotent or its body can be read again from the start.
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of attempts of a request,
+	// one disables the retries.
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry, doubled on each retry.
+	MinBackoff time.Duration
+	// MaxBackoff is the longest wait between two attempts,
+	// including the one asked by a Retry-After header.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryOptions returns the default retry policy.
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts: DefaultMaxAttempts,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+	}
+}
+
+// backoff returns the wait before the given retry, growing exponentially
+// with a random jitter so clients retrying together do not stay in sync.
+func (o RetryOptions) backoff(retry int) time.Duration {
+	d := o.MinBackoff
+	for i := 1; i < retry && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Reopener is a request body that can be read again from its start,
+// so the requests sending it can be retried.
+type Reopener interface {
+	io.Reader
+	Reopen() (io.Reader, error)
+}
+
+type timeoutKey struct{}
+
+// WithTimeout returns a context overriding the timeout of each attempt of the
+// requests made with it. A zero timeout disables it, e.g. for long uploads.
+func WithTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, timeout)
+}
+
+// do sends the request, retrying it according to the retry policy of the
+// client. The returned response body must be closed.
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	attempts := c.Retry.MaxAttempts
+	if attempts < 1 || !retryable(req) {
+		attempts = 1
+	}
+
+	timeout := c.Timeout
+	if t, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		timeout = t
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			actx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		r, err := c.HTTPClient.Do(req.WithContext(actx))
+		if err == nil && !retryableStatus(r.StatusCode) {
+			r.Body = &cancelBody{ReadCloser: r.Body, cancel: cancel}
+			return r, nil
+		}
+		if attempt >= attempts || ctx.Err() != nil {
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			r.Body = &cancelBody{ReadCloser: r.Body, cancel: cancel}
+			return r, nil
+		}
+
+		wait := c.Retry.backoff(attempt)
+		if err == nil {
+			if after, ok := retryAfter(r.Header.Get("Retry-After")); ok && after > wait {
+				wait = after
+			}
+			if wait > c.Retry.MaxBackoff {
+				wait = c.Retry.MaxBackoff
+			}
+			_, _ = io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+		}
+		cancel()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// newRequest creates a request whose body can be sent again if it is a
+// Reopener or a Seeker. The bodies of the standard buffers and readers
+// are already handled by http.NewRequest.
+func newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	switch b := body.(type) {
+	case Reopener:
+		req, err := http.NewRequest(method, path, b)
+		if err != nil {
+			return nil, err
+		}
+		req.GetBody = func() (io.ReadCloser, error) {
+			r, err := b.Reopen()
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(r), nil
+		}
+		return req, nil
+	case io.ReadSeeker:
+		start, err := b.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return http.NewRequest(method, path, body)
+		}
+		end, err := b.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := b.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		// the body is not closed by the transport, so it can be sent again
+		req, err := http.NewRequest(method, path, ioutil.NopCloser(b))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = end - start
+		req.GetBody = func() (io.ReadCloser, error) {
+			if _, err := b.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(b), nil
+		}
+		return req, nil
+	}
+	return http.NewRequest(method, path, body)
+}
+
+// retryable reports whether the request can be sent more than once.
+func retryable(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+			return true
+		}
+		return false
+	}
+	return req.GetBody != nil
+}
+
+// retryableStatus reports whether the status is a temporary failure.
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusAccepted,
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as a date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := time.Until(t)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// cancelBody releases the context of a request once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
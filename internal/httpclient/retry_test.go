@@ -0,0 +1,403 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer answers the requests with the given handlers in turn,
+// repeating the last one, and records the bodies it received.
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	handlers []http.HandlerFunc
+	bodies   []string
+	lengths  []int64
+}
+
+func newTestServer(t *testing.T, handlers ...http.HandlerFunc) *testServer {
+	t.Helper()
+	s := &testServer{handlers: handlers}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		n := len(s.bodies)
+		s.bodies = append(s.bodies, string(body))
+		s.lengths = append(s.lengths, r.ContentLength)
+		h := s.handlers[len(s.handlers)-1]
+		if n < len(s.handlers) {
+			h = s.handlers[n]
+		}
+		s.mu.Unlock()
+		h(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func (s *testServer) client(t *testing.T, o RetryOptions, timeout time.Duration) *Client {
+	t.Helper()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(u, &ClientOptions{Retry: &o, Timeout: timeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func status(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}
+}
+
+func fastRetries(attempts int) RetryOptions {
+	return RetryOptions{
+		MaxAttempts: attempts,
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  5 * time.Millisecond,
+	}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	s := newTestServer(t,
+		status(http.StatusServiceUnavailable),
+		status(http.StatusBadGateway),
+		status(http.StatusGatewayTimeout),
+		status(http.StatusOK),
+	)
+	c := s.client(t, fastRetries(5), 0)
+
+	if err := c.Request(context.Background(), http.MethodGet, "/bytes", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.attempts(); got != 4 {
+		t.Errorf("got %d attempts, want 4", got)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	s := newTestServer(t, status(http.StatusServiceUnavailable))
+	c := s.client(t, fastRetries(3), 0)
+
+	err := c.Request(context.Background(), http.MethodGet, "/bytes", nil, nil)
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Fatalf("got error %v, want %v", err, ErrServiceUnavailable)
+	}
+	if got := s.attempts(); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	s := newTestServer(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		status(http.StatusOK),
+	)
+	o := fastRetries(2)
+	o.MaxBackoff = 5 * time.Second
+	c := s.client(t, o, 0)
+
+	start := time.Now()
+	if err := c.Request(context.Background(), http.MethodGet, "/tags", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the 1s of Retry-After", elapsed)
+	}
+	if got := s.attempts(); got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+}
+
+func TestRetryAfterCappedByMaxBackoff(t *testing.T) {
+	s := newTestServer(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		status(http.StatusOK),
+	)
+	c := s.client(t, fastRetries(2), 0)
+
+	start := time.Now()
+	if err := c.Request(context.Background(), http.MethodGet, "/tags", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retried after %v, want at most the max backoff", elapsed)
+	}
+}
+
+func TestRetryAttemptTimeout(t *testing.T) {
+	s := newTestServer(t,
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		},
+		status(http.StatusOK),
+	)
+	c := s.client(t, fastRetries(2), 50*time.Millisecond)
+
+	start := time.Now()
+	if err := c.Request(context.Background(), http.MethodGet, "/chunks", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("first attempt was not cut by the timeout, took %v", elapsed)
+	}
+	if got := s.attempts(); got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+}
+
+func TestRetryWithTimeoutOverride(t *testing.T) {
+	s := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	})
+	c := s.client(t, fastRetries(1), 10*time.Millisecond)
+
+	ctx := WithTimeout(context.Background(), 0)
+	if err := c.Request(ctx, http.MethodGet, "/bytes", nil, nil); err != nil {
+		t.Fatalf("request without attempt timeout: %v", err)
+	}
+}
+
+// seeker hides the type of the reader, so the request body is only
+// known to be an io.ReadSeeker.
+type seeker struct {
+	io.ReadSeeker
+}
+
+func TestRetryRewindsSeekerBody(t *testing.T) {
+	s := newTestServer(t,
+		status(http.StatusServiceUnavailable),
+		status(http.StatusCreated),
+	)
+	c := s.client(t, fastRetries(3), 0)
+
+	// the body is sent from its current offset
+	body := &seeker{strings.NewReader("skipped:payload")}
+	if _, err := body.Seek(int64(len("skipped:")), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Request(context.Background(), http.MethodPost, "/bytes", body, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.attempts(); got != 2 {
+		t.Fatalf("got %d attempts, want 2", got)
+	}
+	for i := range s.bodies {
+		if s.bodies[i] != "payload" {
+			t.Errorf("attempt %d sent body %q, want %q", i+1, s.bodies[i], "payload")
+		}
+		if s.lengths[i] != int64(len("payload")) {
+			t.Errorf("attempt %d sent content length %d, want %d", i+1, s.lengths[i], len("payload"))
+		}
+	}
+}
+
+// reopener is a body read again from the start of its data on each attempt.
+type reopener struct {
+	io.Reader
+	data    []byte
+	reopens int
+}
+
+func (r *reopener) Reopen() (io.Reader, error) {
+	r.reopens++
+	r.Reader = bytes.NewReader(r.data)
+	return r.Reader, nil
+}
+
+func TestRetryReopensBody(t *testing.T) {
+	s := newTestServer(t,
+		status(http.StatusBadGateway),
+		status(http.StatusServiceUnavailable),
+		status(http.StatusCreated),
+	)
+	c := s.client(t, fastRetries(3), 0)
+
+	data := []byte("tar content")
+	body := &reopener{Reader: bytes.NewReader(data), data: data}
+	if err := c.Request(context.Background(), http.MethodPost, "/bzz", body, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.reopens != 2 {
+		t.Errorf("body reopened %d times, want 2", body.reopens)
+	}
+	for i, b := range s.bodies {
+		if b != string(data) {
+			t.Errorf("attempt %d sent body %q, want %q", i+1, b, data)
+		}
+	}
+}
+
+func TestNoRetryOnClientErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		want   error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusInternalServerError, ErrInternalServerError},
+	} {
+		s := newTestServer(t, status(tc.status), status(http.StatusOK))
+		c := s.client(t, fastRetries(5), 0)
+
+		err := c.Request(context.Background(), http.MethodGet, "/bytes", nil, nil)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("status %d: got error %v, want %v", tc.status, err, tc.want)
+		}
+		if got := s.attempts(); got != 1 {
+			t.Errorf("status %d: got %d attempts, want 1", tc.status, got)
+		}
+	}
+}
+
+func TestNoRetryOnBadRequest(t *testing.T) {
+	s := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, `{"errors":["invalid batch"]}`)
+	})
+	c := s.client(t, fastRetries(5), 0)
+
+	err := c.Request(context.Background(), http.MethodPost, "/stamps", bytes.NewReader([]byte("{}")), nil)
+	var badRequest *BadRequestError
+	if !errors.As(err, &badRequest) || badRequest.Error() != "invalid batch" {
+		t.Fatalf("got error %v, want the bad request errors", err)
+	}
+	if got := s.attempts(); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+}
+
+func TestNoRetryOfUnrewindableBody(t *testing.T) {
+	s := newTestServer(t, status(http.StatusServiceUnavailable), status(http.StatusCreated))
+	c := s.client(t, fastRetries(5), 0)
+
+	// a plain reader cannot be sent again
+	body := ioutil.NopCloser(strings.NewReader("stream"))
+	err := c.Request(context.Background(), http.MethodPost, "/bytes", body, nil)
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Fatalf("got error %v, want %v", err, ErrServiceUnavailable)
+	}
+	if got := s.attempts(); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+}
+
+func TestRetryStopsOnCanceledContext(t *testing.T) {
+	s := newTestServer(t, status(http.StatusServiceUnavailable))
+	o := fastRetries(5)
+	o.MinBackoff, o.MaxBackoff = time.Minute, time.Minute
+	c := s.client(t, o, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.Request(ctx, http.MethodGet, "/bytes", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := s.attempts(); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	o := RetryOptions{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, max := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		5: time.Second,
+		9: time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := o.backoff(retry); d < max/2 || d > max {
+				t.Fatalf("retry %d: got backoff %v, want between %v and %v", retry, d, max/2, max)
+			}
+		}
+	}
+
+	if d := (RetryOptions{}).backoff(1); d != 0 {
+		t.Errorf("got backoff %v without min backoff, want 0", d)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "/bytes", nil)
+	post, _ := http.NewRequest(http.MethodPost, "/tags", nil)
+	buffered, _ := newRequest(http.MethodPost, "/bytes", bytes.NewReader([]byte("data")))
+	streamed, _ := newRequest(http.MethodPost, "/bytes", ioutil.NopCloser(strings.NewReader("data")))
+
+	for name, tc := range map[string]struct {
+		req  *http.Request
+		want bool
+	}{
+		"get without body":  {get, true},
+		"post without body": {post, false},
+		"buffered body":     {buffered, true},
+		"streamed body":     {streamed, false},
+	} {
+		if got := retryable(tc.req); got != tc.want {
+			t.Errorf("%s: got retryable %t, want %t", name, got, tc.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for v, want := range map[string]struct {
+		d  time.Duration
+		ok bool
+	}{
+		"":                              {0, false},
+		"7":                             {7 * time.Second, true},
+		"-1":                            {0, false},
+		"invalid":                       {0, false},
+		"Wed, 21 Oct 2015 07:28:00 GMT": {0, true},
+	} {
+		d, ok := retryAfter(v)
+		if d != want.d || ok != want.ok {
+			t.Errorf("retryAfter(%q) = %v, %t, want %v, %t", v, d, ok, want.d, want.ok)
+		}
+	}
+
+	d, ok := retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if !ok || d < 59*time.Minute || d > time.Hour {
+		t.Errorf("got %v, %t for a date in an hour", d, ok)
+	}
+}
@@ -0,0 +1,227 @@
+// Package kiwix reads the OPDS catalog of the ZIM files published by Kiwix.
+// See: https://wiki.kiwix.org/wiki/OPDS
+package kiwix
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// CatalogURL is the OPDS catalog listing all the ZIM files distributed by Kiwix.
+	CatalogURL = "https://library.kiwix.org/catalog/v2/entries?count=-1"
+
+	acquisitionRel = "http://opds-spec.org/acquisition/open-access"
+	zimMimeType    = "application/x-zim"
+)
+
+// Entry describes a ZIM file in the catalog.
+type Entry struct {
+	ID           string
+	Title        string
+	Summary      string
+	Name         string
+	Language     string
+	Flavour      string
+	Category     string
+	Tags         []string
+	Date         time.Time
+	ArticleCount uint64
+	MediaCount   uint64
+	Size         int64
+	URL          string
+}
+
+// File returns the name of the ZIM file, i.e. the value of --zim.
+func (e Entry) File() string {
+	return path.Base(e.URL)
+}
+
+// Project returns the Kiwix directory hosting the ZIM file, i.e. the value of --kiwix.
+func (e Entry) Project() string {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return e.Category
+	}
+	return path.Base(path.Dir(u.Path))
+}
+
+// FileLanguage returns the language code used in the ZIM file name,
+// which is usually a two letters code instead of the ISO 639-3 used by the catalog.
+func (e Entry) FileLanguage() string {
+	parts := strings.Split(strings.TrimSuffix(e.File(), ".zim"), "_")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+type opdsFeed struct {
+	Entries []opdsEntry `xml:"entry"`
+}
+
+type opdsEntry struct {
+	ID           string     `xml:"id"`
+	Title        string     `xml:"title"`
+	Summary      string     `xml:"summary"`
+	Updated      string     `xml:"updated"`
+	Name         string     `xml:"name"`
+	Language     string     `xml:"language"`
+	Flavour      string     `xml:"flavour"`
+	Category     string     `xml:"category"`
+	Tags         string     `xml:"tags"`
+	ArticleCount uint64     `xml:"articleCount"`
+	MediaCount   uint64     `xml:"mediaCount"`
+	Links        []opdsLink `xml:"link"`
+}
+
+type opdsLink struct {
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Href   string `xml:"href,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+// ParseCatalog parses an OPDS catalog and returns its ZIM entries.
+// Entries without a download link are ignored.
+func ParseCatalog(r io.Reader) ([]Entry, error) {
+	var feed opdsFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("error parsing kiwix catalog: %v", err)
+	}
+
+	entries := make([]Entry, 0, len(feed.Entries))
+	for _, e := range feed.Entries {
+		link, ok := e.acquisitionLink()
+		if !ok {
+			continue
+		}
+
+		entry := Entry{
+			ID:           e.ID,
+			Title:        e.Title,
+			Summary:      e.Summary,
+			Name:         e.Name,
+			Language:     e.Language,
+			Flavour:      e.Flavour,
+			Category:     e.Category,
+			ArticleCount: e.ArticleCount,
+			MediaCount:   e.MediaCount,
+			Size:         link.Length,
+			// the catalog links to the metalink of the file
+			URL: strings.TrimSuffix(link.Href, ".meta4"),
+		}
+
+		for _, tag := range strings.Split(e.Tags, ";") {
+			if tag != "" {
+				entry.Tags = append(entry.Tags, tag)
+			}
+		}
+
+		if entry.Flavour == "" {
+			entry.Flavour = flavourFromTags(entry.Tags)
+		}
+
+		if t, err := time.Parse(time.RFC3339, e.Updated); err == nil {
+			entry.Date = t
+		}
+
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (e opdsEntry) acquisitionLink() (opdsLink, bool) {
+	for _, l := range e.Links {
+		if l.Rel == acquisitionRel && l.Type == zimMimeType {
+			return l, true
+		}
+	}
+	return opdsLink{}, false
+}
+
+// flavourFromTags returns the flavour from the tags of older catalogs.
+func flavourFromTags(tags []string) string {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "_flavour:") {
+			return strings.TrimPrefix(tag, "_flavour:")
+		}
+	}
+	return ""
+}
+
+// FetchCatalog downloads and parses the catalog from the given url.
+// A path to a local file can also be given.
+func FetchCatalog(ctx context.Context, catalogURL string) ([]Entry, error) {
+	u, err := url.Parse(catalogURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		f, err := os.Open(catalogURL)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseCatalog(f)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, catalogURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download catalog failed: %v [status: %v]", catalogURL, resp.Status)
+	}
+
+	return ParseCatalog(resp.Body)
+}
+
+// Filter selects catalog entries. Empty fields match all entries.
+type Filter struct {
+	Project  string
+	Language string
+	Flavour  string
+	Name     string
+}
+
+// Match reports whether the entry matches the filter.
+// The language matches both the catalog and the file name codes.
+func (f Filter) Match(e Entry) bool {
+	if f.Project != "" && f.Project != e.Project() && f.Project != e.Category {
+		return false
+	}
+	if f.Language != "" && f.Language != e.Language && f.Language != e.FileLanguage() {
+		return false
+	}
+	if f.Flavour != "" && f.Flavour != e.Flavour {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(e.Name, f.Name) && !strings.Contains(e.File(), f.Name) {
+		return false
+	}
+	return true
+}
+
+// FilterEntries returns the entries matching the filter.
+func FilterEntries(entries []Entry, f Filter) []Entry {
+	var matches []Entry
+	for _, e := range entries {
+		if f.Match(e) {
+			matches = append(matches, e)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package kiwix
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readTestCatalog(t *testing.T) []Entry {
+	t.Helper()
+	entries, err := FetchCatalog(context.Background(), filepath.Join("testdata", "catalog.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func entryFiles(entries []Entry) []string {
+	var files []string
+	for _, e := range entries {
+		files = append(files, e.File())
+	}
+	return files
+}
+
+func TestParseCatalog(t *testing.T) {
+	entries := readTestCatalog(t)
+
+	// the wiktionary entry has no download link
+	want := []string{
+		"wikipedia_en_all_maxi_2022-03.zim",
+		"wikipedia_en_all_nopic_2022-02.zim",
+		"wikipedia_fr_top_mini_2022-01.zim",
+		"superuser.com_en_all_2022-03.zim",
+	}
+	if got := entryFiles(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got files %v, want %v", got, want)
+	}
+
+	e := entries[0]
+	if e.URL != "https://download.kiwix.org/zim/wikipedia/wikipedia_en_all_maxi_2022-03.zim" {
+		t.Errorf("got url %s, want the zim file of the metalink", e.URL)
+	}
+	if e.Size != 97174118400 || e.ArticleCount != 6456790 || e.MediaCount != 4384765 {
+		t.Errorf("got size %d, %d articles and %d media", e.Size, e.ArticleCount, e.MediaCount)
+	}
+	if !e.Date.Equal(time.Date(2022, 3, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got date %v, want 2022-03-12", e.Date)
+	}
+	if e.Name != "wikipedia_en_all" || e.Language != "eng" || e.Flavour != "maxi" || e.Category != "wikipedia" {
+		t.Errorf("got name %q, language %q, flavour %q and category %q", e.Name, e.Language, e.Flavour, e.Category)
+	}
+	if len(e.Tags) != 6 || e.Tags[0] != "wikipedia" {
+		t.Errorf("got tags %v", e.Tags)
+	}
+	if e.Project() != "wikipedia" || e.FileLanguage() != "en" {
+		t.Errorf("got project %q and file language %q, want wikipedia and en", e.Project(), e.FileLanguage())
+	}
+
+	// older catalogs only give the flavour in the tags
+	if f := entries[2].Flavour; f != "mini" {
+		t.Errorf("got flavour %q from the tags, want mini", f)
+	}
+	if p := entries[3].Project(); p != "stack_exchange" {
+		t.Errorf("got project %q, want stack_exchange", p)
+	}
+}
+
+func TestParseCatalogInvalid(t *testing.T) {
+	if _, err := ParseCatalog(strings.NewReader("<feed><entry>")); err == nil {
+		t.Error("got no error parsing a truncated catalog")
+	}
+}
+
+func TestFilterEntries(t *testing.T) {
+	entries := readTestCatalog(t)
+
+	for _, tc := range []struct {
+		filter Filter
+		want   []string
+	}{
+		{
+			filter: Filter{},
+			want:   entryFiles(entries),
+		},
+		{
+			// the catalog and the file name codes of the language
+			filter: Filter{Language: "eng"},
+			want:   []string{"wikipedia_en_all_maxi_2022-03.zim", "wikipedia_en_all_nopic_2022-02.zim", "superuser.com_en_all_2022-03.zim"},
+		},
+		{
+			filter: Filter{Language: "en"},
+			want:   []string{"wikipedia_en_all_maxi_2022-03.zim", "wikipedia_en_all_nopic_2022-02.zim", "superuser.com_en_all_2022-03.zim"},
+		},
+		{
+			filter: Filter{Project: "wikipedia", Language: "fra"},
+			want:   []string{"wikipedia_fr_top_mini_2022-01.zim"},
+		},
+		{
+			filter: Filter{Project: "stack_exchange"},
+			want:   []string{"superuser.com_en_all_2022-03.zim"},
+		},
+		{
+			filter: Filter{Language: "en", Flavour: "nopic"},
+			want:   []string{"wikipedia_en_all_nopic_2022-02.zim"},
+		},
+		{
+			filter: Filter{Name: "wikipedia_en_all", Flavour: "maxi"},
+			want:   []string{"wikipedia_en_all_maxi_2022-03.zim"},
+		},
+		{
+			// the name matches the file name too
+			filter: Filter{Name: "en_all_nopic"},
+			want:   []string{"wikipedia_en_all_nopic_2022-02.zim"},
+		},
+		{
+			filter: Filter{Language: "de"},
+		},
+	} {
+		if got := entryFiles(FilterEntries(entries, tc.filter)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got files %v for filter %+v, want %v", got, tc.filter, tc.want)
+		}
+	}
+}
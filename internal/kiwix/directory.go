@@ -0,0 +1,172 @@
+package kiwix
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// zimLinkRegex matches the links to zim files in a directory listing.
+var zimLinkRegex = regexp.MustCompile(`href="([^"?#]+\.zim)"`)
+
+// zimDateRegex matches the release date at the end of a zim file name.
+var zimDateRegex = regexp.MustCompile(`_(\d{4}-\d{2})$`)
+
+// flavours lists the known flavours used in the zim file names.
+var flavours = map[string]bool{
+	"mini":  true,
+	"nopic": true,
+	"maxi":  true,
+}
+
+// File is a zim file found in a Kiwix directory listing.
+type File struct {
+	Name string
+	URL  string
+}
+
+// Release returns the name of the zim file without its release date, which is
+// shared by all the releases of the same zim (e.g. wikipedia_en_all_maxi).
+func (f File) Release() string {
+	return zimDateRegex.ReplaceAllString(strings.TrimSuffix(f.Name, ".zim"), "")
+}
+
+// Date returns the release date of the zim file (e.g. 2022-03).
+func (f File) Date() string {
+	m := zimDateRegex.FindStringSubmatch(strings.TrimSuffix(f.Name, ".zim"))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// Language returns the language code from the zim file name.
+func (f File) Language() string {
+	parts := strings.Split(f.Release(), "_")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// Flavour returns the flavour from the zim file name, if any.
+func (f File) Flavour() string {
+	parts := strings.Split(f.Release(), "_")
+	if last := parts[len(parts)-1]; flavours[last] {
+		return last
+	}
+	return ""
+}
+
+// ParseDirectory parses an HTML directory listing and returns the zim files
+// it links to, resolved against the directory url.
+func ParseDirectory(r io.Reader, dirURL string) ([]File, error) {
+	base, err := url.Parse(dirURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var files []File
+	for _, m := range zimLinkRegex.FindAllStringSubmatch(string(data), -1) {
+		u, err := base.Parse(m[1])
+		if err != nil {
+			continue
+		}
+
+		name := path.Base(u.Path)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		files = append(files, File{
+			Name: name,
+			URL:  u.String(),
+		})
+	}
+	return files, nil
+}
+
+// FetchDirectory downloads and parses the directory listing of a Kiwix mirror.
+func FetchDirectory(ctx context.Context, dirURL string) ([]File, error) {
+	if !strings.HasSuffix(dirURL, "/") {
+		dirURL += "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dirURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download directory listing failed: %v [status: %v]", dirURL, resp.Status)
+	}
+
+	return ParseDirectory(resp.Body, dirURL)
+}
+
+// MatchFile reports whether the zim file matches the filter.
+// The project is not checked, since all files in a directory share it.
+func (f Filter) MatchFile(file File) bool {
+	if f.Language != "" && f.Language != file.Language() {
+		return false
+	}
+	if f.Flavour != "" && f.Flavour != file.Flavour() {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(file.Name, f.Name) {
+		return false
+	}
+	return true
+}
+
+// FilterFiles returns the zim files matching the filter.
+func FilterFiles(files []File, f Filter) []File {
+	var matches []File
+	for _, file := range files {
+		if f.MatchFile(file) {
+			matches = append(matches, file)
+		}
+	}
+	return matches
+}
+
+// LatestFiles returns only the latest release of each zim file.
+func LatestFiles(files []File) []File {
+	latest := make(map[string]File)
+	for _, file := range files {
+		if l, ok := latest[file.Release()]; !ok || file.Date() > l.Date() {
+			latest[file.Release()] = file
+		}
+	}
+
+	result := make([]File, 0, len(latest))
+	for _, file := range latest {
+		result = append(result, file)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,146 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package kiwix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readTestDirectory(t *testing.T) []File {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "directory.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	files, err := ParseDirectory(f, "https://download.kiwix.org/zim/wikipedia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func fileNames(files []File) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestParseDirectory(t *testing.T) {
+	files := readTestDirectory(t)
+
+	// checksums, torrents, sorting links and duplicates are left out
+	want := []string{
+		"wikipedia_ab_all_maxi_2021-11.zim",
+		"wikipedia_ab_all_maxi_2022-02.zim",
+		"wikipedia_ab_all_nopic_2022-02.zim",
+		"wikipedia_en_100_maxi_2022-01.zim",
+		"wikipedia_en_100_maxi_2022-03.zim",
+		"wikipedia_en_100_mini_2022-03.zim",
+		"wikipedia_en_100_2022-03.zim",
+		"wikipedia_fr_top_mini_2022-01.zim",
+	}
+	if got := fileNames(files); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got files %v, want %v", got, want)
+	}
+	if u := files[0].URL; u != "https://download.kiwix.org/zim/wikipedia/wikipedia_ab_all_maxi_2021-11.zim" {
+		t.Errorf("got url %s, want it resolved against the directory", u)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		release  string
+		date     string
+		language string
+		flavour  string
+	}{
+		{"wikipedia_en_100_maxi_2022-03.zim", "wikipedia_en_100_maxi", "2022-03", "en", "maxi"},
+		{"wikipedia_en_100_2022-03.zim", "wikipedia_en_100", "2022-03", "en", ""},
+		{"wikipedia_fr_top_mini_2022-01.zim", "wikipedia_fr_top_mini", "2022-01", "fr", "mini"},
+		{"wikipedia.zim", "wikipedia", "", "", ""},
+	} {
+		f := File{Name: tc.name}
+		if f.Release() != tc.release || f.Date() != tc.date || f.Language() != tc.language || f.Flavour() != tc.flavour {
+			t.Errorf("got release %q, date %q, language %q and flavour %q for %s, want %q, %q, %q and %q",
+				f.Release(), f.Date(), f.Language(), f.Flavour(), tc.name, tc.release, tc.date, tc.language, tc.flavour)
+		}
+	}
+}
+
+func TestLatestFiles(t *testing.T) {
+	files := readTestDirectory(t)
+
+	for _, tc := range []struct {
+		filter Filter
+		want   []string
+	}{
+		{
+			filter: Filter{},
+			want: []string{
+				"wikipedia_ab_all_maxi_2022-02.zim",
+				"wikipedia_ab_all_nopic_2022-02.zim",
+				"wikipedia_en_100_2022-03.zim",
+				"wikipedia_en_100_maxi_2022-03.zim",
+				"wikipedia_en_100_mini_2022-03.zim",
+				"wikipedia_fr_top_mini_2022-01.zim",
+			},
+		},
+		{
+			filter: Filter{Language: "en", Flavour: "maxi"},
+			want:   []string{"wikipedia_en_100_maxi_2022-03.zim"},
+		},
+		{
+			filter: Filter{Language: "ab", Name: "nopic"},
+			want:   []string{"wikipedia_ab_all_nopic_2022-02.zim"},
+		},
+		{
+			// the project is given by the directory
+			filter: Filter{Project: "stack_exchange", Language: "fr"},
+			want:   []string{"wikipedia_fr_top_mini_2022-01.zim"},
+		},
+		{
+			filter: Filter{Language: "de"},
+		},
+	} {
+		if got := fileNames(LatestFiles(FilterFiles(files, tc.filter))); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got latest files %v for filter %+v, want %v", got, tc.filter, tc.want)
+		}
+	}
+}
+
+func TestFetchDirectory(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/zim/wikipedia/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join("testdata", "directory.html"))
+	}))
+	defer srv.Close()
+
+	files, err := FetchDirectory(context.Background(), srv.URL+"/zim/wikipedia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 8 || files[0].URL != srv.URL+"/zim/wikipedia/wikipedia_ab_all_maxi_2021-11.zim" {
+		t.Errorf("got files %v, want the 8 zim files of the directory", files)
+	}
+
+	if _, err := FetchDirectory(context.Background(), srv.URL+"/zim/missing"); err == nil {
+		t.Error("got no error fetching a missing directory")
+	}
+}
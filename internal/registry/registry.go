@@ -0,0 +1,168 @@
+// Package registry keeps a local record of the mirrors published to Swarm,
+// so their references are not lost and the same ZIM is not uploaded twice.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the registry file.
+const FileName = "registry.json"
+
+// Record describes a mirror published to Swarm.
+type Record struct {
+	Zim         string    `json:"zim,omitempty"`
+	ZimChecksum string    `json:"zimChecksum,omitempty"`
+	Tar         string    `json:"tar"`
+	TarChecksum string    `json:"tarChecksum"`
+	Reference   string    `json:"reference"`
+	BatchID     string    `json:"batchID"`
+	Tag         uint32    `json:"tag,omitempty"`
+	Pin         bool      `json:"pin"`
+	Encrypted   bool      `json:"encrypted,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// Registry is a list of records persisted as a JSON file.
+type Registry struct {
+	mu      sync.Mutex
+	path    string
+	records []Record
+}
+
+// DefaultPath returns the path of the registry in the user home directory.
+func DefaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".beezim", FileName), nil
+}
+
+// Open loads the registry from the given path.
+// The file is only created when the registry is saved.
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &r.records); err != nil {
+		return nil, fmt.Errorf("error parsing registry %s: %v", path, err)
+	}
+	return r, nil
+}
+
+// Path returns the path of the registry file.
+func (r *Registry) Path() string {
+	return r.path
+}
+
+// Records returns all records ordered by their timestamp.
+func (r *Registry) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make([]Record, len(r.records))
+	copy(records, r.records)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+	return records
+}
+
+// Add adds a record and saves the registry.
+func (r *Registry) Add(rec Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now().UTC()
+	}
+	r.records = append(r.records, rec)
+	return r.save()
+}
+
+// Find returns the records whose reference, zim or tar name match the key.
+func (r *Registry) Find(key string) []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found []Record
+	for _, rec := range r.records {
+		if rec.matches(key) {
+			found = append(found, rec)
+		}
+	}
+	return found
+}
+
+// FindByZimChecksum returns the last record of a zim with the given checksum,
+// uploaded encrypted or not.
+func (r *Registry) FindByZimChecksum(checksum string, encrypted bool) (Record, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.records) - 1; i >= 0; i-- {
+		if checksum != "" && r.records[i].ZimChecksum == checksum && r.records[i].Encrypted == encrypted {
+			return r.records[i], true
+		}
+	}
+	return Record{}, false
+}
+
+// Forget removes the records matching the key, saves the registry
+// and returns how many records were removed.
+func (r *Registry) Forget(key string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.records[:0]
+	for _, rec := range r.records {
+		if !rec.matches(key) {
+			kept = append(kept, rec)
+		}
+	}
+	removed := len(r.records) - len(kept)
+	r.records = kept
+
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, r.save()
+}
+
+func (rec Record) matches(key string) bool {
+	return key != "" && (rec.Reference == key || rec.Zim == key || rec.Tar == key)
+}
+
+// save writes the registry to a temporary file that replaces the
+// previous one, so an interrupted write never corrupts it.
+func (r *Registry) save() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(r.records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,444 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+// Package zimfile writes ZIM files, which gozim can only read.
+//
+// The files are written in version 5.0 with the old namespace scheme
+// and uncompressed clusters, which are read by gozim, libzim and the
+// Kiwix readers.
+// See: https://openzim.org/wiki/ZIM_file_format
+package zimfile
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	magicNumber  = 72173914
+	majorVersion = 5
+	minorVersion = 0
+	headerSize   = 80
+
+	noPage          = 0xffffffff
+	redirectEntry   = 0xffff
+	linkTargetEntry = 0xfffe
+
+	compressionNone = 1
+
+	// readAhead is the size, with some margin, gozim reads past the start
+	// of the MIME types list and of the directory entries to parse them.
+	readAhead = 4096
+
+	// ClusterSize is the size above which a cluster is written.
+	// Bigger entries are written alone in their own cluster.
+	ClusterSize = 1 << 20
+)
+
+// DefaultMimeType is the MIME type of the entries added without one.
+const DefaultMimeType = "application/octet-stream"
+
+var (
+	// ErrDuplicateEntry is returned when an entry is added twice.
+	ErrDuplicateEntry = errors.New("zimfile: duplicate entry")
+	// ErrMissingTarget is wrapped by the error returned by Close when
+	// redirects were dropped because their target was not added.
+	ErrMissingTarget = errors.New("zimfile: missing redirect target")
+)
+
+// MissingTargetError lists the redirects dropped from the file.
+type MissingTargetError struct {
+	Redirects []string
+}
+
+func (e *MissingTargetError) Error() string {
+	return fmt.Sprintf("%v of %d redirects", ErrMissingTarget, len(e.Redirects))
+}
+
+func (e *MissingTargetError) Unwrap() error {
+	return ErrMissingTarget
+}
+
+// Entry is an entry of a ZIM file.
+type Entry struct {
+	Namespace byte
+	URL       string
+	Title     string
+	// MimeType is the type of a content entry, DefaultMimeType if empty.
+	MimeType string
+}
+
+// FullURL returns the namespace and url of the entry, as in "A/Main_Page".
+func (e Entry) FullURL() string {
+	return string(e.Namespace) + "/" + e.URL
+}
+
+// sortTitle returns the title the entry is sorted by in the title index.
+func (e Entry) sortTitle() string {
+	if e.Title == "" {
+		return e.URL
+	}
+	return e.Title
+}
+
+type dirent struct {
+	Entry
+	mime    uint16
+	cluster uint32
+	blob    uint32
+	// target is the full url of the entry a redirect points to
+	target     string
+	isRedirect bool
+}
+
+// Writer writes the entries of a ZIM file.
+type Writer struct {
+	w        io.Writer
+	mimes    map[string]uint16
+	mimeList []string
+	entries  []*dirent
+	urls     map[string]struct{}
+	mainPage string
+
+	// clusters are written to a temporary file until the size of the
+	// directory entries written before them is known
+	tmp      *os.File
+	pos      uint64
+	clusters []uint64
+	// blobs holds the data of the cluster being filled
+	blobs   [][]byte
+	blobLen int
+}
+
+// NewWriter returns a writer of a ZIM file to w. The content of the entries
+// is kept in a temporary file in tmpDir until the writer is closed, or in
+// the default directory for temporary files if tmpDir is empty.
+func NewWriter(w io.Writer, tmpDir string) (*Writer, error) {
+	tmp, err := os.CreateTemp(tmpDir, "zimfile-*.clusters")
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:     w,
+		mimes: make(map[string]uint16),
+		urls:  make(map[string]struct{}),
+		tmp:   tmp,
+	}, nil
+}
+
+// Add adds a content entry with the size bytes read from r.
+func (zw *Writer) Add(e Entry, r io.Reader, size int64) error {
+	if err := zw.addURL(e); err != nil {
+		return err
+	}
+	if e.MimeType == "" {
+		e.MimeType = DefaultMimeType
+	}
+	mime, ok := zw.mimes[e.MimeType]
+	if !ok {
+		if len(zw.mimeList) >= linkTargetEntry {
+			return fmt.Errorf("zimfile: too many MIME types")
+		}
+		mime = uint16(len(zw.mimeList))
+		zw.mimes[e.MimeType] = mime
+		zw.mimeList = append(zw.mimeList, e.MimeType)
+	}
+	if size > math.MaxUint32-16 {
+		return fmt.Errorf("zimfile: %s: entry too big", e.FullURL())
+	}
+
+	d := &dirent{Entry: e, mime: mime}
+	if size > ClusterSize {
+		// big entries are streamed in their own cluster
+		if err := zw.flushCluster(); err != nil {
+			return err
+		}
+		d.cluster = uint32(len(zw.clusters))
+		zw.entries = append(zw.entries, d)
+		return zw.writeCluster(nil, r, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("zimfile: read %s: %w", e.FullURL(), err)
+	}
+	if zw.blobLen+len(data) > ClusterSize {
+		if err := zw.flushCluster(); err != nil {
+			return err
+		}
+	}
+	d.cluster = uint32(len(zw.clusters))
+	d.blob = uint32(len(zw.blobs))
+	zw.blobs = append(zw.blobs, data)
+	zw.blobLen += len(data)
+	zw.entries = append(zw.entries, d)
+	return nil
+}
+
+// AddRedirect adds a redirect entry to the entry with the given full url,
+// as in "A/Main_Page". The target must be added before the file is closed.
+func (zw *Writer) AddRedirect(e Entry, target string) error {
+	if err := zw.addURL(e); err != nil {
+		return err
+	}
+	zw.entries = append(zw.entries, &dirent{Entry: e, target: target, isRedirect: true})
+	return nil
+}
+
+// SetMainPage sets the entry with the given full url as the main page.
+func (zw *Writer) SetMainPage(fullURL string) {
+	zw.mainPage = fullURL
+}
+
+func (zw *Writer) addURL(e Entry) error {
+	if e.Namespace == 0 || e.URL == "" {
+		return fmt.Errorf("zimfile: entry without namespace or url")
+	}
+	if _, ok := zw.urls[e.FullURL()]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateEntry, e.FullURL())
+	}
+	zw.urls[e.FullURL()] = struct{}{}
+	return nil
+}
+
+// Close writes the header, the indexes and the directory entries of the
+// file, followed by the clusters and the checksum. Redirects whose target
+// is missing are dropped, and reported by a MissingTargetError once the
+// file is completed.
+func (zw *Writer) Close() error {
+	defer zw.Abort()
+
+	if err := zw.flushCluster(); err != nil {
+		return err
+	}
+
+	sort.Slice(zw.entries, func(i, j int) bool {
+		a, b := zw.entries[i], zw.entries[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.URL < b.URL
+	})
+
+	// redirects to missing entries would make the file invalid,
+	// and dropping them may leave other redirects without target
+	var missing []string
+	index := urlIndex(zw.entries)
+	for {
+		entries := make([]*dirent, 0, len(zw.entries))
+		for _, d := range zw.entries {
+			if _, ok := index[d.target]; d.isRedirect && !ok {
+				missing = append(missing, d.FullURL())
+				continue
+			}
+			entries = append(entries, d)
+		}
+		if len(entries) == len(zw.entries) {
+			break
+		}
+		zw.entries = entries
+		index = urlIndex(zw.entries)
+	}
+	if len(zw.entries) == 0 {
+		return fmt.Errorf("zimfile: no entries")
+	}
+
+	titles := make([]uint32, len(zw.entries))
+	for i := range titles {
+		titles[i] = uint32(i)
+	}
+	sort.SliceStable(titles, func(i, j int) bool {
+		a, b := zw.entries[titles[i]], zw.entries[titles[j]]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.sortTitle() < b.sortTitle()
+	})
+
+	var mimeList bytes.Buffer
+	for _, m := range zw.mimeList {
+		mimeList.WriteString(m)
+		mimeList.WriteByte(0)
+	}
+	mimeList.WriteByte(0)
+
+	// the directory entries are written before the clusters, since
+	// readers like gozim read past their end to find their url
+	direntsPos := uint64(headerSize+mimeList.Len()) + uint64(len(zw.entries))*12
+	urlPtrs := make([]uint64, len(zw.entries))
+	var dirents bytes.Buffer
+	for i, d := range zw.entries {
+		urlPtrs[i] = direntsPos + uint64(dirents.Len())
+		if d.isRedirect {
+			writeInts(&dirents, uint16(redirectEntry), uint8(0), d.Namespace, uint32(0), index[d.target])
+		} else {
+			writeInts(&dirents, d.mime, uint8(0), d.Namespace, uint32(0), d.cluster, d.blob)
+		}
+		dirents.WriteString(d.URL)
+		dirents.WriteByte(0)
+		if d.Title != d.URL {
+			dirents.WriteString(d.Title)
+		}
+		dirents.WriteByte(0)
+	}
+
+	clusterPtrPos := direntsPos + uint64(dirents.Len())
+	clustersPos := clusterPtrPos + uint64(len(zw.clusters))*8
+	clusterPtrs := make([]uint64, len(zw.clusters))
+	for i, c := range zw.clusters {
+		clusterPtrs[i] = clustersPos + c
+	}
+	// small files are padded after their last cluster,
+	// so gozim does not read past their end
+	var padding []byte
+	if end := clusterPtrPos + readAhead; clustersPos+zw.pos < end {
+		padding = make([]byte, end-clustersPos-zw.pos)
+	}
+	checksumPos := clustersPos + zw.pos + uint64(len(padding))
+
+	mainPage := uint32(noPage)
+	if i, ok := index[zw.mainPage]; ok {
+		mainPage = i
+	}
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	writeInts(&buf,
+		uint32(magicNumber), uint16(majorVersion), uint16(minorVersion), uuid,
+		uint32(len(zw.entries)), uint32(len(zw.clusters)),
+		uint64(headerSize+mimeList.Len()), uint64(headerSize+mimeList.Len())+uint64(len(zw.entries))*8,
+		clusterPtrPos, uint64(headerSize),
+		mainPage, uint32(noPage), checksumPos,
+	)
+	buf.Write(mimeList.Bytes())
+	writeInts(&buf, urlPtrs, titles)
+
+	// the checksum covers the whole file
+	h := md5.New()
+	w := io.MultiWriter(zw.w, h)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(dirents.Bytes()); err != nil {
+		return err
+	}
+	buf.Reset()
+	writeInts(&buf, clusterPtrs)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := zw.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, zw.tmp); err != nil {
+		return fmt.Errorf("zimfile: copy clusters: %w", err)
+	}
+	if _, err := w.Write(padding); err != nil {
+		return err
+	}
+	if _, err := zw.w.Write(h.Sum(nil)); err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return &MissingTargetError{Redirects: missing}
+	}
+	return nil
+}
+
+// Abort removes the temporary file of a writer that will not be closed.
+// It does nothing once the writer is closed.
+func (zw *Writer) Abort() error {
+	zw.tmp.Close()
+	if err := os.Remove(zw.tmp.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// urlIndex maps the full urls of the sorted entries to their index.
+func urlIndex(entries []*dirent) map[string]uint32 {
+	index := make(map[string]uint32, len(entries))
+	for i, d := range entries {
+		index[d.FullURL()] = uint32(i)
+	}
+	return index
+}
+
+// flushCluster writes the cluster being filled, if any.
+func (zw *Writer) flushCluster() error {
+	if len(zw.blobs) == 0 {
+		return nil
+	}
+	blobs := zw.blobs
+	zw.blobs, zw.blobLen = nil, 0
+	return zw.writeCluster(blobs, nil, 0)
+}
+
+// writeCluster writes an uncompressed cluster with the given blobs,
+// or with the single blob of size bytes read from r.
+func (zw *Writer) writeCluster(blobs [][]byte, r io.Reader, size int64) error {
+	zw.clusters = append(zw.clusters, zw.pos)
+
+	sizes := make([]int64, 0, len(blobs)+1)
+	for _, b := range blobs {
+		sizes = append(sizes, int64(len(b)))
+	}
+	if r != nil {
+		sizes = append(sizes, size)
+	}
+
+	// the offsets of the blobs, followed by the end of the last one
+	var buf bytes.Buffer
+	buf.WriteByte(compressionNone)
+	offset := uint32(4 * (len(sizes) + 1))
+	for _, s := range sizes {
+		writeInts(&buf, offset)
+		offset += uint32(s)
+	}
+	writeInts(&buf, offset)
+	if err := zw.write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	for _, b := range blobs {
+		if err := zw.write(b); err != nil {
+			return err
+		}
+	}
+	if r != nil {
+		n, err := io.CopyN(zw.tmp, r, size)
+		zw.pos += uint64(n)
+		if err != nil {
+			return fmt.Errorf("zimfile: write cluster: %w", err)
+		}
+	}
+	return nil
+}
+
+func (zw *Writer) write(b []byte) error {
+	n, err := zw.tmp.Write(b)
+	zw.pos += uint64(n)
+	return err
+}
+
+// writeInts writes the values in little endian, as all ZIM integers.
+func writeInts(buf *bytes.Buffer, values ...interface{}) {
+	for _, v := range values {
+		// writing to a buffer does not fail
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+}
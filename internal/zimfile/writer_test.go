@@ -0,0 +1,244 @@
+// Copyright 2022 Beezim Authors.
+// All rights reserved.
+// Use of this source code is governed by GPLv3
+// license that can be found in the LICENSE file.
+
+package zimfile
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	zim "github.com/akhenakh/gozim"
+)
+
+type testEntry struct {
+	Entry
+	data []byte
+	// target is the full url of the target of a redirect
+	target string
+}
+
+var testEntries = []testEntry{
+	{Entry: Entry{Namespace: 'A', URL: "Main_Page", Title: "Main Page", MimeType: "text/html"}, data: []byte("<h1>Main Page</h1>")},
+	{Entry: Entry{Namespace: 'A', URL: "Swarm", Title: "Swarm", MimeType: "text/html"}, data: []byte("<h1>Swarm</h1>")},
+	// a title equal to the url is not written, as in the files of kiwix
+	{Entry: Entry{Namespace: 'A', URL: "Zim", Title: "Zim"}, data: []byte("zim")},
+	// bigger than a cluster, so written in its own cluster
+	{Entry: Entry{Namespace: 'I', URL: "logo.png", MimeType: "image/png"}, data: bytes.Repeat([]byte("png"), ClusterSize/2)},
+	{Entry: Entry{Namespace: 'M', URL: "Title", MimeType: "text/plain"}, data: []byte("Test")},
+	{Entry: Entry{Namespace: 'A', URL: "Bee", Title: "Bee"}, target: "A/Swarm"},
+	{Entry: Entry{Namespace: 'A', URL: "Hive", Title: "Hive"}, target: "A/Bee"},
+}
+
+// writeTestZim writes the entries to a zim file and returns its path.
+func writeTestZim(t *testing.T, entries []testEntry, mainPage string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	p := filepath.Join(dir, "test.zim")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w, err := NewWriter(f, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.target != "" {
+			err = w.AddRedirect(e.Entry, e.target)
+		} else {
+			err = w.Add(e.Entry, bytes.NewReader(e.data), int64(len(e.data)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.SetMainPage(mainPage)
+	return p, w.Close()
+}
+
+// readArticles reads the articles of the zim file by full url.
+func readArticles(t *testing.T, z *zim.ZimReader) map[string]*zim.Article {
+	t.Helper()
+	articles := make(map[string]*zim.Article)
+	for i := uint32(0); i < z.ArticleCount; i++ {
+		offset, err := z.OffsetAtURLIdx(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// articles of the pool of gozim may keep the title of a previous one
+		a := new(zim.Article)
+		if err := z.FillArticleAt(a, offset); err != nil {
+			t.Fatal(err)
+		}
+		articles[a.FullURL()] = a
+	}
+	return articles
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	p, err := writeTestZim(t, testEntries, "A/Main_Page")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zim.NewReader(p, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	if int(z.ArticleCount) != len(testEntries) {
+		t.Fatalf("got %d articles, want %d", z.ArticleCount, len(testEntries))
+	}
+	articles := readArticles(t, z)
+
+	for _, e := range testEntries {
+		a, ok := articles[e.FullURL()]
+		if !ok {
+			t.Errorf("missing %s", e.FullURL())
+			continue
+		}
+		if a.Namespace != e.Namespace {
+			t.Errorf("got namespace %c for %s, want %c", a.Namespace, e.FullURL(), e.Namespace)
+		}
+		title := e.Title
+		if title == e.URL {
+			title = ""
+		}
+		if a.Title != title {
+			t.Errorf("got title %q for %s, want %q", a.Title, e.FullURL(), title)
+		}
+
+		if e.target != "" {
+			idx, err := a.RedirectIndex()
+			if err != nil {
+				t.Errorf("%s: %v", e.FullURL(), err)
+				continue
+			}
+			target, err := z.ArticleAtURLIdx(idx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target.FullURL() != e.target {
+				t.Errorf("got redirect of %s to %s, want %s", e.FullURL(), target.FullURL(), e.target)
+			}
+			continue
+		}
+
+		mimeType := e.MimeType
+		if mimeType == "" {
+			mimeType = DefaultMimeType
+		}
+		if a.MimeType() != mimeType {
+			t.Errorf("got MIME type %q for %s, want %q", a.MimeType(), e.FullURL(), mimeType)
+		}
+		data, err := a.Data()
+		if err != nil {
+			t.Fatalf("%s: %v", e.FullURL(), err)
+		}
+		if !bytes.Equal(data, e.data) {
+			t.Errorf("got %d bytes of content for %s, want %d bytes", len(data), e.FullURL(), len(e.data))
+		}
+	}
+
+	main, err := z.MainPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main == nil || main.FullURL() != "A/Main_Page" {
+		t.Errorf("got main page %v, want A/Main_Page", main)
+	}
+
+	// the url index is sorted, as the binary search of gozim requires.
+	// The search never looks at the first url, A/Bee.
+	for _, e := range testEntries {
+		if e.FullURL() == "A/Bee" {
+			continue
+		}
+		if _, err := z.GetPageNoIndex(e.FullURL()); err != nil {
+			t.Errorf("find %s: %v", e.FullURL(), err)
+		}
+	}
+
+	// the title index is sorted by namespace and title
+	var titles []string
+	z.ListTitlesPtrIterator(func(idx uint32) {
+		a, err := z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles = append(titles, a.FullURL())
+	})
+	wantTitles := []string{"A/Bee", "A/Hive", "A/Main_Page", "A/Swarm", "A/Zim", "I/logo.png", "M/Title"}
+	if !reflect.DeepEqual(titles, wantTitles) {
+		t.Errorf("got title index %v, want %v", titles, wantTitles)
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(data[:len(data)-md5.Size])
+	if !bytes.Equal(sum[:], data[len(data)-md5.Size:]) {
+		t.Error("checksum does not match the content of the file")
+	}
+}
+
+func TestWriterDropsMissingTargets(t *testing.T) {
+	entries := append([]testEntry{
+		{Entry: Entry{Namespace: 'A', URL: "Gone", Title: "Gone"}, target: "A/Missing"},
+		// the redirect to a dropped redirect is dropped too
+		{Entry: Entry{Namespace: 'A', URL: "Lost", Title: "Lost"}, target: "A/Gone"},
+	}, testEntries...)
+
+	p, err := writeTestZim(t, entries, "A/Missing")
+	var missing *MissingTargetError
+	if !errors.As(err, &missing) || !errors.Is(err, ErrMissingTarget) {
+		t.Fatalf("got error %v, want a missing target error", err)
+	}
+	if want := []string{"A/Gone", "A/Lost"}; !reflect.DeepEqual(missing.Redirects, want) {
+		t.Errorf("got dropped redirects %v, want %v", missing.Redirects, want)
+	}
+
+	z, err := zim.NewReader(p, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if int(z.ArticleCount) != len(testEntries) {
+		t.Errorf("got %d articles, want the %d entries with a target", z.ArticleCount, len(testEntries))
+	}
+	if main, err := z.MainPage(); err != nil || main != nil {
+		t.Errorf("got main page %v, %v, want none", main, err)
+	}
+}
+
+func TestWriterDuplicateEntry(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.zim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewWriter(f, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Abort()
+
+	e := Entry{Namespace: 'A', URL: "Swarm"}
+	if err := w.Add(e, bytes.NewReader(nil), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddRedirect(e, "A/Swarm"); !errors.Is(err, ErrDuplicateEntry) {
+		t.Errorf("got error %v adding an entry twice, want %v", err, ErrDuplicateEntry)
+	}
+}